@@ -0,0 +1,274 @@
+package yeelight
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// ErrConnectionLimit is returned when no connection slot was freed within the timeout, see WithMaxConnections
+var ErrConnectionLimit = errors.New("too many connections to the bulb")
+
+// ConnState is state of the command connection to the bulb
+type ConnState int32
+
+const (
+	Disconnected ConnState = iota
+	Connecting
+	Connected
+	// MusicMode means commands are sent over the music connection
+	MusicMode
+)
+
+var connStateNames = map[ConnState]string{
+	Disconnected: "Disconnected",
+	Connecting:   "Connecting",
+	Connected:    "Connected",
+	MusicMode:    "MusicMode",
+}
+
+func (s ConnState) String() string {
+	if name, ok := connStateNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("ConnState(%d)", int(s))
+}
+
+// ConnState returns current state of the command connection. Without keep-alive,
+// the bulb is Connected only while a command is running
+func (y *Bulb) ConnState() ConnState {
+	return ConnState(atomic.LoadInt32(&y.connState))
+}
+
+// setConnState updates state of the command connection, MusicMode is kept until music mode is stopped
+func (y *Bulb) setConnState(s ConnState) {
+	for {
+		old := atomic.LoadInt32(&y.connState)
+		if ConnState(old) == MusicMode || atomic.CompareAndSwapInt32(&y.connState, old, int32(s)) {
+			return
+		}
+	}
+}
+
+// setMusicState switches state to MusicMode or back, the next command reports its own connection
+func (y *Bulb) setMusicState(on bool) {
+	if on {
+		atomic.StoreInt32(&y.connState, int32(MusicMode))
+		return
+	}
+	atomic.StoreInt32(&y.connState, int32(Disconnected))
+}
+
+// client runs commands on connections to the bulb
+type client interface {
+	// execute writes command and reads its reply. If primed is set, it reports the result
+	// of connecting and waits until fire is closed before writing the command
+	execute(cmd *Command, primed chan<- error, fire <-chan struct{}) (*CommandResult, error)
+	// close releases all connections held by the client
+	close()
+}
+
+// newClient creates client selected by options
+func (y *Bulb) newClient() client {
+	if y.keepAlive {
+		return &persistentClient{y: y}
+	}
+	return &dialClient{y: y}
+}
+
+// dialClient opens new connection for every command, so commands run in parallel
+// up to the connection limit
+type dialClient struct {
+	y *Bulb
+	// active is number of open connections, accessed atomically
+	active int32
+}
+
+func (c *dialClient) execute(cmd *Command, primed chan<- error, fire <-chan struct{}) (*CommandResult, error) {
+	c.y.waitRateLimit()
+
+	if atomic.LoadInt32(&c.active) == 0 {
+		c.y.setConnState(Connecting)
+	}
+	conn, err := c.y.dial()
+	if nil != err {
+		if atomic.LoadInt32(&c.active) == 0 {
+			c.y.setConnState(Disconnected)
+		}
+		prime(primed, fire, err)
+		return nil, err
+	}
+	atomic.AddInt32(&c.active, 1)
+	c.y.setConnState(Connected)
+	defer func() {
+		closeConnection(conn)
+		c.y.releaseConn()
+		if atomic.AddInt32(&c.active, -1) == 0 {
+			c.y.setConnState(Disconnected)
+		}
+	}()
+
+	prime(primed, fire, nil)
+	return c.y.roundTrip(conn, bufio.NewReader(conn), cmd)
+}
+
+func (c *dialClient) close() {}
+
+// persistentClient keeps single connection between commands and reconnects when it breaks
+type persistentClient struct {
+	y *Bulb
+
+	// mu queues commands, so each reply is read before the next command is written
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func (c *persistentClient) execute(cmd *Command, primed chan<- error, fire <-chan struct{}) (*CommandResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.y.waitRateLimit()
+
+	err := c.connect()
+	prime(primed, fire, err)
+	if nil != err {
+		return nil, err
+	}
+	rs, err := c.y.roundTrip(c.conn, c.reader, cmd)
+	if nil != err {
+		// drop broken connection so the next command reconnects
+		c.disconnect()
+	}
+	return rs, err
+}
+
+// connect opens the connection unless it is open already, the mutex has to be held
+func (c *persistentClient) connect() error {
+	if nil != c.conn {
+		c.y.drain(c.reader)
+		// state may have been reset by music mode
+		c.y.setConnState(Connected)
+		return nil
+	}
+
+	c.y.setConnState(Connecting)
+	conn, err := c.y.dial()
+	if nil != err {
+		c.y.setConnState(Disconnected)
+		return err
+	}
+	c.y.setConnState(Connected)
+	c.conn = conn
+	c.reader = bufio.NewReader(conn)
+	return nil
+}
+
+func (c *persistentClient) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.disconnect()
+}
+
+// disconnect closes the connection, the mutex has to be held
+func (c *persistentClient) disconnect() {
+	if nil != c.conn {
+		closeConnection(c.conn)
+		c.y.releaseConn()
+		c.y.setConnState(Disconnected)
+	}
+	c.conn = nil
+	c.reader = nil
+}
+
+// ping keeps persistent connection alive, it sends get_prop when no command was sent for ping interval
+func (y *Bulb) ping() {
+	ticker := time.NewTicker(y.pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-y.pingStop:
+			return
+		case <-ticker.C:
+		}
+
+		idle := y.sinceLastCmd()
+		//skip when a command was sent recently, half interval tolerates previous ping sent after its tick
+		if idle < y.pingInterval/2 {
+			continue
+		}
+		if _, err := y.execute(y.newCommand("get_prop", []interface{}{"power"})); err != nil {
+			y.logger.Printf("keep-alive ping failed. %s", err)
+		}
+	}
+}
+
+// drain handles complete lines already buffered on reused connection, e.g. notifications received
+// between commands. Power reported by notifications is remembered. It does not wait for the network
+func (y *Bulb) drain(reader *bufio.Reader) {
+	for {
+		buffered, _ := reader.Peek(reader.Buffered())
+		if bytes.IndexByte(buffered, '\n') < 0 {
+			return
+		}
+		line, _ := reader.ReadString('\n')
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		n, err := parseNotification(line, false)
+		if err == nil && n.Method == "props" {
+			if power, ok := n.Params["power"]; ok {
+				y.setKnownPower(power == "on")
+			}
+			continue
+		}
+		y.logger.Printf("stale data discarded: %s", strings.TrimSpace(line))
+	}
+}
+
+// dial opens connection to the bulb within the connection limit
+func (y *Bulb) dial() (net.Conn, error) {
+	if err := y.acquireConn(context.Background()); err != nil {
+		return nil, err
+	}
+	conn, err := net.DialTimeout("tcp", y.address(), y.timeout)
+	if nil != err {
+		y.releaseConn()
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			return nil, fmt.Errorf("cannot open connection to %s. %w", y.address(), ErrLANControlDisabled)
+		}
+		return nil, fmt.Errorf("cannot open connection to %s. %s", y.address(), err)
+	}
+	return conn, nil
+}
+
+// acquireConn waits until the bulb connection limit allows opening one more connection.
+// It gives up after the timeout or when ctx is done
+func (y *Bulb) acquireConn(ctx context.Context) error {
+	timer := time.NewTimer(y.timeout)
+	defer timer.Stop()
+
+	select {
+	case y.conns <- struct{}{}:
+		return nil
+	case <-timer.C:
+		return fmt.Errorf("cannot open connection to %s. %w", y.address(), ErrConnectionLimit)
+	case <-ctx.Done():
+		return fmt.Errorf("cannot open connection to %s. %w", y.address(), ctx.Err())
+	}
+}
+
+// releaseConn frees connection slot taken by acquireConn
+func (y *Bulb) releaseConn() {
+	<-y.conns
+}
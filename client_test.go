@@ -0,0 +1,361 @@
+package yeelight
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMaxConnectionsLimitsConcurrentCommands(t *testing.T) {
+	f := newFakeBulb(t)
+	var active, peak int32
+	f.reply(func(cmd Command) []string {
+		n := atomic.AddInt32(&active, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt32(&active, -1)
+		return nil
+	})
+	y := f.newBulb(WithMaxConnections(2))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := y.ExecuteCommand("set_bright", 10); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&peak); got > 2 {
+		t.Errorf("expected at most 2 commands at once, got %d", got)
+	}
+	if got := len(f.received()); got != 6 {
+		t.Errorf("expected all 6 commands to be sent, got %d", got)
+	}
+}
+
+func TestConnectionLimitTimesOut(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb(WithMaxConnections(1), WithTimeout(100*time.Millisecond))
+
+	l, err := y.NewListener()
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	defer l.Close()
+
+	if _, err := y.ExecuteCommand("set_bright", 10); !errors.Is(err, ErrConnectionLimit) {
+		t.Fatalf("expected ErrConnectionLimit, got %v", err)
+	}
+	if methods := f.methods(); len(methods) != 0 {
+		t.Errorf("expected nothing sent, got %v", methods)
+	}
+}
+
+func TestListenContextStopsWaitingForConnectionSlot(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb(WithMaxConnections(1), WithTimeout(waitTimeout))
+
+	l, err := y.NewListener()
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	defer l.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := y.ListenContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= waitTimeout {
+		t.Errorf("listener waited for the whole timeout, %s", elapsed)
+	}
+}
+
+func TestWithMaxConnectionsIgnoresInvalidValues(t *testing.T) {
+	for _, n := range []int{0, -1} {
+		y := NewWithOptions("127.0.0.1", WithMaxConnections(n))
+		if cap(y.conns) != defaultMaxConnections {
+			t.Errorf("WithMaxConnections(%d) set limit %d", n, cap(y.conns))
+		}
+	}
+}
+
+// clientModes are both command clients, per command dialing and keep-alive
+var clientModes = []struct {
+	name      string
+	keepAlive bool
+}{
+	{"dial", false},
+	{"keep-alive", true},
+}
+
+func TestClientsSatisfySameContract(t *testing.T) {
+	for _, mode := range clientModes {
+		f := newFakeBulb(t)
+		f.reply(func(cmd Command) []string {
+			if cmd.Method == "set_name" {
+				return []string{errorLine(cmd.ID, -1, "unsupported")}
+			}
+			return nil
+		})
+		y := f.newBulb(WithKeepAlive(mode.keepAlive))
+
+		res, err := y.ExecuteCommand("set_power", "on")
+		if err != nil || res.ID != f.last("set_power").ID {
+			t.Errorf("%s: expected matching result, got %v %v", mode.name, res, err)
+		}
+		var e *Error
+		if _, err := y.ExecuteCommand("set_name", "desk"); !errors.As(err, &e) || e.Message != "unsupported" {
+			t.Errorf("%s: expected bulb error, got %v", mode.name, err)
+		}
+		if _, err := y.ExecuteCommand("set_bright", 10); err != nil {
+			t.Errorf("%s: bulb error broke the client, %s", mode.name, err)
+		}
+	}
+}
+
+func TestClientsReportUnreachableBulb(t *testing.T) {
+	for _, mode := range clientModes {
+		y := NewWithOptions("127.0.0.1", WithPort(refusedPort(t)), WithKeepAlive(mode.keepAlive),
+			WithTimeout(time.Second), WithLogger(quietLogger))
+
+		if _, err := y.ExecuteCommand("set_power", "on"); !errors.Is(err, ErrLANControlDisabled) {
+			t.Errorf("%s: expected ErrLANControlDisabled, got %v", mode.name, err)
+		}
+		if state := y.ConnState(); state != Disconnected {
+			t.Errorf("%s: expected disconnected state, got %s", mode.name, state)
+		}
+		y.Close()
+	}
+}
+
+func TestKeepAliveReusesConnection(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb(WithKeepAlive(true))
+
+	for i := 0; i < 3; i++ {
+		if _, err := y.ExecuteCommand("set_bright", 10); err != nil {
+			t.Fatalf("unexpected error %s", err)
+		}
+	}
+	if got := f.acceptedConns(); got != 1 {
+		t.Errorf("expected a single connection, got %d", got)
+	}
+}
+
+func TestKeepAliveReconnectsAfterConnectionDrop(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb(WithKeepAlive(true))
+
+	if _, err := y.ExecuteCommand("set_bright", 10); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	f.dropConns()
+	f.waitConns(0)
+
+	// the first command may only find out the connection is broken
+	if _, err := y.ExecuteCommand("set_bright", 20); err != nil {
+		if _, err := y.ExecuteCommand("set_bright", 20); err != nil {
+			t.Fatalf("client did not reconnect, %s", err)
+		}
+	}
+	if got := f.acceptedConns(); got != 2 {
+		t.Errorf("expected reconnection, got %d connections", got)
+	}
+}
+
+func TestConnStateTransitions(t *testing.T) {
+	f := newFakeBulb(t)
+	f.enableMusic()
+	y := f.newBulb(WithKeepAlive(true))
+
+	if got := y.ConnState(); got != Disconnected {
+		t.Fatalf("expected Disconnected before the first command, got %s", got)
+	}
+	if _, err := y.TurnOn(); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if got := y.ConnState(); got != Connected {
+		t.Errorf("expected Connected after command, got %s", got)
+	}
+	if err := y.StartMusicMode("127.0.0.1", 0); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if got := y.ConnState(); got != MusicMode {
+		t.Errorf("expected MusicMode, got %s", got)
+	}
+	if _, err := y.GetProps([]string{"power"}); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if got := y.ConnState(); got != MusicMode {
+		t.Errorf("expected MusicMode kept by get_prop, got %s", got)
+	}
+	if err := y.StopMusicMode(); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if _, err := y.TurnOn(); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if got := y.ConnState(); got != Connected {
+		t.Errorf("expected Connected after music mode, got %s", got)
+	}
+	y.Close()
+	if got := y.ConnState(); got != Disconnected {
+		t.Errorf("expected Disconnected after Close, got %s", got)
+	}
+}
+
+func TestDialClientIsConnectedOnlyDuringCommand(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb()
+	states := make(chan ConnState, 1)
+	f.reply(func(cmd Command) []string {
+		states <- y.ConnState()
+		return nil
+	})
+
+	if _, err := y.TurnOn(); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if got := <-states; got != Connected {
+		t.Errorf("expected Connected while command runs, got %s", got)
+	}
+	if got := y.ConnState(); got != Disconnected {
+		t.Errorf("expected Disconnected after command, got %s", got)
+	}
+}
+
+func TestDrainConsumesOnlyCompleteBufferedLines(t *testing.T) {
+	var logs bytes.Buffer
+	y := NewWithOptions("127.0.0.1", WithLogger(log.New(&logs, "", 0)))
+	stale := okLine(7)
+	reader := bufio.NewReader(strings.NewReader(propsLine(map[string]string{"power": "off"}) + crlf + crlf + stale + crlf + `{"id":8,`))
+	// fill the buffer as a previous read would
+	reader.Peek(1)
+
+	y.drain(reader)
+
+	if on, known := y.knownPower(); !known || on {
+		t.Errorf("expected power off remembered from notification, got %t %t", on, known)
+	}
+	if !strings.Contains(logs.String(), "stale data discarded: "+stale) {
+		t.Errorf("expected stale reply to be logged, got %q", logs.String())
+	}
+	if rest, _ := ioutil.ReadAll(reader); string(rest) != `{"id":8,` {
+		t.Errorf("expected partial line kept, got %q", rest)
+	}
+}
+
+func TestKeepAliveDrainsStaleDataBeforeCommand(t *testing.T) {
+	f := newFakeBulb(t)
+	f.reply(func(cmd Command) []string {
+		if cmd.Method == "set_bright" {
+			// reply, notification and stale reply arrive in a single write
+			return []string{okLine(cmd.ID) + crlf + propsLine(map[string]string{"power": "off"}) + crlf + okLine(cmd.ID+100)}
+		}
+		return nil
+	})
+	var logs syncBuffer
+	y := f.newBulb(WithKeepAlive(true), WithLogger(log.New(&logs, "", 0)))
+
+	if _, err := y.ExecuteCommand("set_bright", 10); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	res, err := y.ExecuteCommand("set_power", "on")
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if res.ID != f.last("set_power").ID {
+		t.Errorf("expected reply of set_power, got id %d", res.ID)
+	}
+	if !strings.Contains(logs.String(), "stale data discarded") {
+		t.Errorf("expected stale reply to be drained, got %q", logs.String())
+	}
+}
+
+// syncBuffer is bytes.Buffer safe for concurrent use by loggers
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestPingKeepsIdleConnectionAlive(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb(WithKeepAlive(true), WithPingInterval(30*time.Millisecond))
+
+	for _, cmd := range f.waitCommands(2) {
+		if cmd.Method != "get_prop" || paramsOf(cmd)[0] != "power" {
+			t.Errorf("expected get_prop power ping, got %s %v", cmd.Method, paramsOf(cmd))
+		}
+	}
+	if got := f.acceptedConns(); got != 1 {
+		t.Errorf("expected pings over a single connection, got %d", got)
+	}
+
+	y.Close()
+	// a ping may still be in flight when Close is called
+	time.Sleep(40 * time.Millisecond)
+	sent := len(f.received())
+	time.Sleep(100 * time.Millisecond)
+	if got := len(f.received()); got != sent {
+		t.Errorf("expected no pings after Close, got %d more", got-sent)
+	}
+}
+
+func TestPingSkippedWhileCommandsAreSent(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb(WithKeepAlive(true), WithPingInterval(100*time.Millisecond))
+
+	for i := 0; i < 20; i++ {
+		if _, err := y.ExecuteCommand("set_bright", 10); err != nil {
+			t.Fatalf("unexpected error %s", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	for _, method := range f.methods() {
+		if method == "get_prop" {
+			t.Fatalf("expected no pings on a busy connection, got %v", f.methods())
+		}
+	}
+}
+
+func TestNoPingsWithoutKeepAlive(t *testing.T) {
+	f := newFakeBulb(t)
+	f.newBulb(WithPingInterval(20 * time.Millisecond))
+
+	time.Sleep(100 * time.Millisecond)
+	if methods := f.methods(); len(methods) != 0 {
+		t.Errorf("expected no pings for dial client, got %v", methods)
+	}
+}
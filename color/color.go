@@ -1,7 +1,12 @@
 package color
 
 import (
+	"fmt"
+	"github.com/lucasb-eyer/go-colorful"
 	"image/color"
+	"math"
+	"strconv"
+	"strings"
 )
 
 func RGBToYeelight(color color.RGBA) int {
@@ -9,5 +14,77 @@ func RGBToYeelight(color color.RGBA) int {
 	g := int(color.G)
 	b := int(color.B)
 
-	return r * 65536 + g * 256 + b
-}
\ No newline at end of file
+	return r*65536 + g*256 + b
+}
+
+// YeelightToRGB unpacks Yeelight rgb integer into color
+func YeelightToRGB(value int) color.RGBA {
+	return color.RGBA{
+		R: uint8(value >> 16 & 0xff),
+		G: uint8(value >> 8 & 0xff),
+		B: uint8(value & 0xff),
+		A: 255,
+	}
+}
+
+// HSVToRGB converts hue (0-359) and saturation (0-100) at full value to color
+func HSVToRGB(hue int, saturation int) color.RGBA {
+	r, g, b := colorful.Hsv(float64(hue), float64(saturation)/100, 1).RGB255()
+	return color.RGBA{R: r, G: g, B: b, A: 255}
+}
+
+// XYToRGB converts CIE 1931 xy chromaticity to color at full value, brightness is set separately.
+// Coordinates are clamped to 0-1 and out of gamut colors to the nearest sRGB color
+func XYToRGB(x, y float64) color.RGBA {
+	x = math.Min(math.Max(x, 0), 1)
+	y = math.Min(math.Max(y, 0), 1)
+	if y == 0 {
+		return color.RGBA{A: 255}
+	}
+
+	r, g, b := colorful.XyzToLinearRgb(colorful.XyyToXyz(x, y, 1))
+	r, g, b = math.Max(r, 0), math.Max(g, 0), math.Max(b, 0)
+	max := math.Max(r, math.Max(g, b))
+	if max == 0 {
+		return color.RGBA{A: 255}
+	}
+
+	r8, g8, b8 := colorful.LinearRgb(r/max, g/max, b/max).Clamped().RGB255()
+	return color.RGBA{R: r8, G: g8, B: b8, A: 255}
+}
+
+// Complementary returns color with hue rotated by 180°
+func Complementary(c color.RGBA) color.RGBA {
+	return rotateHue(c, 180)
+}
+
+// Analogous returns colors with hue rotated by -30° and +30°
+func Analogous(c color.RGBA) [2]color.RGBA {
+	return [2]color.RGBA{rotateHue(c, -30), rotateHue(c, 30)}
+}
+
+// Triadic returns colors with hue rotated by -120° and +120°
+func Triadic(c color.RGBA) [2]color.RGBA {
+	return [2]color.RGBA{rotateHue(c, -120), rotateHue(c, 120)}
+}
+
+// rotateHue rotates hue of color in HSV space keeping its saturation and value
+func rotateHue(c color.RGBA, degrees float64) color.RGBA {
+	h, s, v := colorful.Color{R: float64(c.R) / 255, G: float64(c.G) / 255, B: float64(c.B) / 255}.Hsv()
+	h = math.Mod(h+degrees+360, 360)
+	r, g, b := colorful.Hsv(h, s, v).RGB255()
+	return color.RGBA{R: r, G: g, B: b, A: c.A}
+}
+
+// ParseHex parses color given as "#RRGGBB" or "RRGGBB", case insensitive
+func ParseHex(hex string) (color.RGBA, error) {
+	s := strings.TrimPrefix(hex, "#")
+	if len(s) != 6 {
+		return color.RGBA{}, fmt.Errorf("invalid hex color %q, use #RRGGBB", hex)
+	}
+	value, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid hex color %q, use #RRGGBB", hex)
+	}
+	return YeelightToRGB(int(value)), nil
+}
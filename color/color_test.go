@@ -0,0 +1,108 @@
+package color
+
+import (
+	"github.com/lucasb-eyer/go-colorful"
+	"image/color"
+	"math"
+	"testing"
+)
+
+// near reports whether colors differ at most by tolerance in every channel
+func near(a, b color.RGBA, tolerance int) bool {
+	diff := func(x, y uint8) int {
+		d := int(x) - int(y)
+		if d < 0 {
+			return -d
+		}
+		return d
+	}
+	return diff(a.R, b.R) <= tolerance && diff(a.G, b.G) <= tolerance && diff(a.B, b.B) <= tolerance && a.A == b.A
+}
+
+func TestXYToRGB(t *testing.T) {
+	tests := []struct {
+		name string
+		x, y float64
+		want color.RGBA
+	}{
+		{"white point", 0.3127, 0.3290, color.RGBA{255, 255, 255, 255}},
+		{"red primary", 0.64, 0.33, color.RGBA{255, 0, 0, 255}},
+		{"green primary", 0.30, 0.60, color.RGBA{0, 255, 0, 255}},
+		{"blue primary", 0.15, 0.06, color.RGBA{0, 0, 255, 255}},
+	}
+	for _, tt := range tests {
+		if got := XYToRGB(tt.x, tt.y); !near(got, tt.want, 2) {
+			t.Errorf("%s (%v, %v) converted to %v, want %v", tt.name, tt.x, tt.y, got, tt.want)
+		}
+	}
+}
+
+func TestXYToRGBClampsOutOfGamut(t *testing.T) {
+	for _, xy := range [][2]float64{{0.1, 0.8}, {-1, 2}, {0.7, 0.29}} {
+		got := XYToRGB(xy[0], xy[1])
+		if got.A != 255 || RGBToYeelight(got) == 0 {
+			t.Errorf("(%v, %v) converted to %v, expected visible color", xy[0], xy[1], got)
+		}
+	}
+	if got := XYToRGB(0.3, 0); got != (color.RGBA{A: 255}) {
+		t.Errorf("expected black for y 0, got %v", got)
+	}
+}
+
+// hueOf returns hue of c in degrees
+func hueOf(c color.RGBA) float64 {
+	h, _, _ := colorful.Color{R: float64(c.R) / 255, G: float64(c.G) / 255, B: float64(c.B) / 255}.Hsv()
+	return h
+}
+
+// hueDistance returns signed hue difference from a to b in -180-180 degrees
+func hueDistance(a, b color.RGBA) float64 {
+	return math.Mod(hueOf(b)-hueOf(a)+540, 360) - 180
+}
+
+func TestColorHarmonies(t *testing.T) {
+	base := color.RGBA{R: 255, G: 128, A: 255}
+	tests := []struct {
+		name   string
+		color  color.RGBA
+		offset float64
+	}{
+		{"complementary", Complementary(base), 180},
+		{"analogous -30", Analogous(base)[0], -30},
+		{"analogous +30", Analogous(base)[1], 30},
+		{"triadic -120", Triadic(base)[0], -120},
+		{"triadic +120", Triadic(base)[1], 120},
+	}
+	for _, tt := range tests {
+		got := hueDistance(base, tt.color)
+		if math.Abs(math.Abs(got)-math.Abs(tt.offset)) > 1 || (tt.offset != 180 && got*tt.offset < 0) {
+			t.Errorf("%s rotated hue by %.1f°, want %.0f°", tt.name, got, tt.offset)
+		}
+		if tt.color.A != base.A {
+			t.Errorf("%s changed alpha to %d", tt.name, tt.color.A)
+		}
+	}
+}
+
+func TestComplementaryOfRedIsCyan(t *testing.T) {
+	if got := Complementary(color.RGBA{R: 255, A: 255}); got != (color.RGBA{G: 255, B: 255, A: 255}) {
+		t.Errorf("expected cyan, got %v", got)
+	}
+}
+
+func TestParseHex(t *testing.T) {
+	for _, hex := range []string{"#FF8000", "ff8000"} {
+		got, err := ParseHex(hex)
+		if err != nil {
+			t.Fatalf("unexpected error %s", err)
+		}
+		if want := (color.RGBA{R: 255, G: 128, B: 0, A: 255}); got != want {
+			t.Errorf("ParseHex(%q) = %v, want %v", hex, got, want)
+		}
+	}
+	for _, hex := range []string{"", "#FFF", "#GG0000", "#FF00000"} {
+		if _, err := ParseHex(hex); err == nil {
+			t.Errorf("expected error for %q", hex)
+		}
+	}
+}
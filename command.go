@@ -3,12 +3,21 @@ package yeelight
 import (
 	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"math"
 	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
+// ErrLANControlDisabled is returned when the bulb refuses TCP connections,
+// which usually means "LAN Control" is switched off in the Yeelight app
+var ErrLANControlDisabled = errors.New("connection refused, make sure LAN Control is enabled in the Yeelight app")
+
 //Command represents COMMAND request to Bulb device
 type Command struct {
 	ID     int           `json:"id"`
@@ -21,14 +30,64 @@ type CommandResult struct {
 	ID     int           `json:"id"`
 	Result []interface{} `json:"result,omitempty"`
 	Error  *Error        `json:"error,omitempty"`
+	// Latency is time from writing the command to its reply or failure
+	Latency time.Duration `json:"-"`
+}
+
+// ResultStrings returns result elements converted to strings
+func (r *CommandResult) ResultStrings() []string {
+	strs := make([]string, len(r.Result))
+	for i, val := range r.Result {
+		strs[i] = propString(val)
+	}
+	return strs
+}
+
+// propString converts decoded JSON value to string
+func propString(val interface{}) string {
+	switch v := val.(type) {
+	case string:
+		return v
+	case float64:
+		//avoid exponent format of big numbers like rgb
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprintf("%v", v)
+	}
 }
 
 func (y *Bulb) ExecuteCommand(name string, params ...interface{}) (*CommandResult, error) {
-	return y.execute(y.newCommand(name, params))
+	res, err := y.execute(y.newCommand(name, params))
+	if err == nil {
+		switch name {
+		case "set_power":
+			y.setKnownPower(len(params) > 0 && params[0] == "on")
+		case "set_scene":
+			y.setKnownPower(true)
+		}
+	}
+
+	y.lastMu.Lock()
+	y.lastResult, y.lastErr = res, err
+	y.lastMu.Unlock()
+
+	return res, err
+}
+
+// LastResult returns result and error of the most recent command
+func (y *Bulb) LastResult() (*CommandResult, error) {
+	y.lastMu.Lock()
+	defer y.lastMu.Unlock()
+
+	return y.lastResult, y.lastErr
 }
 
+// newCommand creates command. A single slice param is used as the params array itself,
+// so ExecuteCommand("get_prop", []string{"power"}) sends "params":["power"]
 func (y *Bulb) newCommand(name string, params []interface{}) *Command {
-	if len(params) > 0 {
+	if len(params) == 1 {
 		switch v := params[0].(type) {
 		case []interface{}:
 			params = v
@@ -41,6 +100,10 @@ func (y *Bulb) newCommand(name string, params []interface{}) *Command {
 		default:
 		}
 	}
+	// bulb expects params array even for commands without params
+	if params == nil {
+		params = []interface{}{}
+	}
 
 	return &Command{
 		Method: name,
@@ -50,34 +113,175 @@ func (y *Bulb) newCommand(name string, params []interface{}) *Command {
 }
 
 func (y *Bulb) execute(cmd *Command) (*CommandResult, error) {
-	conn, err := net.Dial("tcp", y.addr)
+	return y.executeSync(cmd, nil, nil)
+}
+
+// executeSync executes command. If primed is set, it reports the result of connecting
+// and waits until fire is closed before writing the command
+func (y *Bulb) executeSync(cmd *Command, primed chan<- error, fire <-chan struct{}) (*CommandResult, error) {
+	y.queueDepthChanged(atomic.AddInt32(&y.queued, 1))
+	defer func() {
+		y.queueDepthChanged(atomic.AddInt32(&y.queued, -1))
+	}()
+
+	y.mu.Lock()
+	if nil != y.musicConn && cmd.Method != "get_prop" {
+		defer y.mu.Unlock()
+		prime(primed, fire, nil)
+		return y.sendMusic(cmd)
+	}
+	y.mu.Unlock()
+
+	rs, err := y.client.execute(cmd, primed, fire)
 	if nil != err {
-		return nil, fmt.Errorf("cannot open connection to %s. %s", y.addr, err)
+		return rs, err
+	}
+	if nil != rs.Error {
+		return rs, fmt.Errorf("command execution error. %w", rs.Error)
+	}
+	return rs, nil
+}
+
+// prime reports result of connecting and waits until fire is closed, nothing is done when primed is nil
+func prime(primed chan<- error, fire <-chan struct{}, err error) {
+	if nil == primed {
+		return
 	}
-	//time.Sleep(time.Second)
-	conn.SetReadDeadline(time.Now().Add(timeout))
+	primed <- err
+	if nil == err {
+		<-fire
+	}
+}
+
+// roundTrip writes command to conn and reads its reply. Errors reported by the bulb
+// are left in the result, returned error means the connection is not usable anymore
+func (y *Bulb) roundTrip(conn net.Conn, reader *bufio.Reader, cmd *Command) (*CommandResult, error) {
+	conn.SetReadDeadline(time.Now().Add(y.timeout))
 
 	//write request/command
+	start := time.Now()
+	failed := func() *CommandResult {
+		return &CommandResult{ID: cmd.ID, Latency: time.Since(start)}
+	}
 	b, _ := json.Marshal(cmd)
-	fmt.Fprint(conn, string(b)+crlf)
+	if _, err := fmt.Fprint(conn, string(b)+crlf); err != nil {
+		return failed(), fmt.Errorf("cannot send command %s", err)
+	}
+	y.record(recordSent, string(b))
 
 	//wait and read for response
-	res, err := bufio.NewReader(conn).ReadString('\n')
-	if err != nil {
-		return nil, fmt.Errorf("cannot read command result %s", err)
+	for {
+		res, err := reader.ReadString('\n')
+		if err != nil {
+			return failed(), fmt.Errorf("cannot read command result %s", err)
+		}
+		y.record(recordReceived, res)
+		var rs struct {
+			CommandResult
+			Method string `json:"method"`
+		}
+		if err := json.Unmarshal([]byte(res), &rs); err != nil {
+			return failed(), fmt.Errorf("cannot parse command result %s", err)
+		}
+		// connection also receives notifications, skip them. They have method
+		// and no id, so they would match command 0 by the id alone
+		if rs.Method == "" && rs.ID == cmd.ID {
+			rs.Latency = time.Since(start)
+			return &rs.CommandResult, nil
+		}
 	}
-	var rs CommandResult
-	err = json.Unmarshal([]byte(res), &rs)
-	if nil != err {
-		return nil, fmt.Errorf("cannot parse command result %s", err)
+}
+
+// Close closes persistent connection opened in keep-alive mode and stops pings
+func (y *Bulb) Close() error {
+	if nil != y.pingStop {
+		y.pingOnce.Do(func() {
+			close(y.pingStop)
+		})
 	}
-	if nil != rs.Error {
-		return nil, fmt.Errorf("command execution error. Code: %d, Message: %s", rs.Error.Code, rs.Error.Message)
+
+	y.client.close()
+	return nil
+}
+
+// waitRateLimit sleeps until the configured interval since the previous command has passed.
+// Concurrent commands reserve consecutive slots, so they stay spaced
+func (y *Bulb) waitRateLimit() {
+	y.rateMu.Lock()
+	now := time.Now()
+	wait := y.rateLimit - now.Sub(y.lastCmd)
+	if y.rateLimit <= 0 || wait < 0 {
+		wait = 0
+	}
+	y.lastCmd = now.Add(wait)
+	y.rateMu.Unlock()
+
+	time.Sleep(wait)
+}
+
+// sinceLastCmd returns time passed since the latest command was sent
+func (y *Bulb) sinceLastCmd() time.Duration {
+	y.rateMu.Lock()
+	defer y.rateMu.Unlock()
+
+	return time.Since(y.lastCmd)
+}
+
+// Prefixes of recorded lines, see RecordTo
+const (
+	recordSent     = "> "
+	recordReceived = "< "
+)
+
+// RecordTo records sent commands and received lines to w, one per line prefixed with "> " or "< ".
+// The recording can be replayed with testutil.ReplayServer. Nil w stops recording
+func (y *Bulb) RecordTo(w io.Writer) {
+	y.recMu.Lock()
+	defer y.recMu.Unlock()
+
+	y.recorder = w
+}
+
+// record writes line to recorder
+func (y *Bulb) record(prefix string, line string) {
+	y.recMu.Lock()
+	defer y.recMu.Unlock()
+
+	if nil == y.recorder {
+		return
+	}
+	if _, err := fmt.Fprintln(y.recorder, prefix+strings.TrimSpace(line)); err != nil {
+		y.logger.Printf("cannot record session %s", err)
+	}
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("Code: %d, Message: %s", e.Code, e.Message)
+}
+
+// isMethodNotSupported reports whether the bulb rejected command as unsupported
+func isMethodNotSupported(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Message == "method not supported"
+}
+
+// isInvalidParams reports whether the bulb rejected params of command, e.g. an extended form it does not know
+func isInvalidParams(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Message == "invalid params"
+}
+
+// queueDepthChanged reports number of queued and running commands to the hook
+func (y *Bulb) queueDepthChanged(depth int32) {
+	if y.onQueueDepth != nil {
+		y.onQueueDepth(int(depth))
 	}
-	return &rs, nil
 }
 
 func (y *Bulb) getCmdId() int {
+	y.idMu.Lock()
+	defer y.idMu.Unlock()
+
 	if y.cmdId == math.MaxInt32 {
 		y.cmdId = 0
 	}
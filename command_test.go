@@ -0,0 +1,326 @@
+package yeelight
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/akominch/yeelight/testutil"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// orderServer reads commands as soon as they arrive and answers them slowly, one by one,
+// echoing their params. It records when each command arrived and was answered
+type orderServer struct {
+	listener net.Listener
+
+	mu       sync.Mutex
+	arrived  []time.Time
+	answered []time.Time
+}
+
+func newOrderServer(t *testing.T) *orderServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot start server %s", err)
+	}
+	s := &orderServer{listener: listener}
+	t.Cleanup(func() {
+		listener.Close()
+	})
+	go s.serve()
+	return s
+}
+
+func (s *orderServer) serve() {
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	commands := make(chan Command, 100)
+	go func() {
+		defer close(commands)
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			var cmd Command
+			json.Unmarshal([]byte(line), &cmd)
+			s.mu.Lock()
+			s.arrived = append(s.arrived, time.Now())
+			s.mu.Unlock()
+			commands <- cmd
+		}
+	}()
+	for cmd := range commands {
+		time.Sleep(10 * time.Millisecond)
+		s.mu.Lock()
+		s.answered = append(s.answered, time.Now())
+		s.mu.Unlock()
+		fmt.Fprint(conn, resultLine(cmd.ID, paramsOf(cmd)...)+crlf)
+	}
+}
+
+func TestKeepAliveSerializesConcurrentCommands(t *testing.T) {
+	s := newOrderServer(t)
+	var mu sync.Mutex
+	depth, peak := 0, 0
+	y := NewWithOptions("127.0.0.1", WithPort(s.listener.Addr().(*net.TCPAddr).Port), WithKeepAlive(true),
+		WithTimeout(time.Second), WithLogger(quietLogger), WithQueueDepthHook(func(d int) {
+			mu.Lock()
+			defer mu.Unlock()
+			depth = d
+			if d > peak {
+				peak = d
+			}
+		}))
+	defer y.Close()
+
+	const commands = 8
+	var wg sync.WaitGroup
+	for i := 0; i < commands; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			res, err := y.ExecuteCommand("set_name", fmt.Sprintf("bulb %d", i))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if got := res.ResultStrings(); len(got) != 1 || got[0] != fmt.Sprintf("bulb %d", i) {
+				t.Errorf("command %d got reply of another command %v", i, got)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.arrived) != commands {
+		t.Fatalf("expected %d commands, got %d", commands, len(s.arrived))
+	}
+	for i := 1; i < commands; i++ {
+		if s.arrived[i].Before(s.answered[i-1]) {
+			t.Fatalf("command %d was written before reply of the previous one", i)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if peak < 2 || depth != 0 {
+		t.Errorf("expected queue depth to grow and return to 0, got peak %d and final %d", peak, depth)
+	}
+}
+
+func TestNotificationIsNotTakenForReplyOfCommandZero(t *testing.T) {
+	for _, keepAlive := range []bool{false, true} {
+		f := newFakeBulb(t)
+		f.reply(func(cmd Command) []string {
+			if cmd.Method == "get_prop" {
+				return []string{propsLine(map[string]string{"power": "off"}), resultLine(cmd.ID, "on")}
+			}
+			return nil
+		})
+		y := f.newBulb(WithKeepAlive(keepAlive))
+
+		res, err := y.GetProps([]string{"power"})
+		if err != nil {
+			t.Fatalf("unexpected error %s", err)
+		}
+		if res.ID != 0 || res.Result["power"] != "on" {
+			t.Errorf("keep-alive %t: expected reply of command 0, got %+v", keepAlive, res)
+		}
+	}
+}
+
+func TestDialClientRunsCommandsInParallel(t *testing.T) {
+	f := newFakeBulb(t)
+	release := make(chan struct{})
+	f.reply(func(cmd Command) []string {
+		<-release
+		return nil
+	})
+	y := f.newBulb()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := y.ExecuteCommand("set_bright", 10); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	// all commands reach the bulb while none was answered yet
+	f.waitCommands(3)
+	close(release)
+	wg.Wait()
+}
+
+func TestLastResultIsSafeForConcurrentCommands(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			y.ExecuteCommand("set_bright", 10)
+		}()
+		go func() {
+			defer wg.Done()
+			y.LastResult()
+		}()
+	}
+	wg.Wait()
+
+	res, err := y.LastResult()
+	if err != nil || res == nil || res.ResultStrings()[0] != "ok" {
+		t.Errorf("expected ok result, got %+v %v", res, err)
+	}
+}
+
+func TestLastResultKeepsError(t *testing.T) {
+	f := newFakeBulb(t)
+	f.reply(func(cmd Command) []string {
+		return []string{errorLine(cmd.ID, -1, "invalid params")}
+	})
+	y := f.newBulb()
+
+	_, cmdErr := y.ExecuteCommand("set_bright", 1000)
+	res, err := y.LastResult()
+	if err == nil || err != cmdErr {
+		t.Errorf("expected error of the last command, got %v", err)
+	}
+	if res == nil || res.Error == nil || res.Error.Message != "invalid params" {
+		t.Errorf("expected result with bulb error, got %+v", res)
+	}
+}
+
+func TestResultStringsConvertsMixedTypes(t *testing.T) {
+	var res CommandResult
+	if err := json.Unmarshal([]byte(`{"id":1,"result":["on",16711680,1.5,true,null]}`), &res); err != nil {
+		t.Fatalf("cannot decode result %s", err)
+	}
+
+	want := []string{"on", "16711680", "1.5", "true", ""}
+	if got := res.ResultStrings(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestCommandParamsSerialization(t *testing.T) {
+	y := NewWithOptions("127.0.0.1")
+	flow := NewFlow(2, Stay, nil).Sleep(500)
+
+	tests := []struct {
+		name   string
+		method string
+		params []interface{}
+		want   string
+	}{
+		{"prop slice", "get_prop", []interface{}{[]string{"power", "bright"}}, `"params":["power","bright"]`},
+		{"start params", "start_cf", []interface{}{flow.AsStartParams()}, `"params":[2,1,"500,7,0,0"]`},
+		{"variadic", "set_power", []interface{}{"on", Smooth, 500}, `"params":["on","smooth",500]`},
+		{"nested slice among params", "set_scene", []interface{}{"cf", []int{1, 2}}, `"params":["cf",[1,2]]`},
+		{"no params", "get_delayoff", nil, `"params":[]`},
+	}
+	for _, tt := range tests {
+		b, err := json.Marshal(y.newCommand(tt.method, tt.params))
+		if err != nil {
+			t.Fatalf("%s: cannot encode command %s", tt.name, err)
+		}
+		if !strings.Contains(string(b), tt.want) {
+			t.Errorf("%s: expected %s in %s", tt.name, tt.want, b)
+		}
+	}
+}
+
+func TestLatencyIsMeasuredForDelayedReply(t *testing.T) {
+	f := newFakeBulb(t)
+	f.reply(func(cmd Command) []string {
+		time.Sleep(30 * time.Millisecond)
+		return nil
+	})
+	y := f.newBulb()
+
+	res, err := y.ExecuteCommand("set_bright", 10)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if res.Latency < 30*time.Millisecond {
+		t.Errorf("expected latency of at least 30ms, got %s", res.Latency)
+	}
+}
+
+func TestLatencyIsSetOnFailure(t *testing.T) {
+	f := newFakeBulb(t)
+	f.reply(func(cmd Command) []string {
+		return []string{}
+	})
+	y := f.newBulb(WithTimeout(50 * time.Millisecond))
+
+	res, err := y.ExecuteCommand("set_bright", 10)
+	if err == nil {
+		t.Fatal("expected timeout error")
+	}
+	if res == nil || res.Latency < 50*time.Millisecond {
+		t.Errorf("expected latency up to the failure, got %+v", res)
+	}
+}
+
+func TestRecordToPrefixesSentAndReceivedLines(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb()
+	var rec bytes.Buffer
+	y.RecordTo(&rec)
+
+	if _, err := y.ExecuteCommand("set_bright", 10); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	y.RecordTo(nil)
+	if _, err := y.ExecuteCommand("set_bright", 20); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(rec.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "> ") || !strings.HasPrefix(lines[1], "< ") {
+		t.Fatalf("expected sent and received line, got %q", lines)
+	}
+	if !strings.Contains(lines[0], `"set_bright"`) || !strings.Contains(lines[1], `"result":["ok"]`) {
+		t.Errorf("unexpected recording %q", lines)
+	}
+}
+
+func TestRecordingReplays(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb()
+	var rec bytes.Buffer
+	y.RecordTo(&rec)
+	if _, err := y.ExecuteCommand("set_bright", 10); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	s, err := testutil.NewReplayServer(&rec)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	defer s.Close()
+	replayed := NewWithOptions(s.Host(), WithPort(s.Port()), WithLogger(quietLogger))
+	if _, err := replayed.ExecuteCommand("set_bright", 10); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if !s.Done() || s.Err() != nil {
+		t.Errorf("expected recording replayed, got %t %v", s.Done(), s.Err())
+	}
+}
@@ -0,0 +1,96 @@
+package yeelight
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// delayOffTick is interval of DelayOffCountdown updates
+const delayOffTick = time.Second
+
+// GetDelayOff reads remaining time of the sleep timer, zero when the timer is not set
+func (y *Bulb) GetDelayOff() (time.Duration, error) {
+	res, err := y.GetProps([]string{"delayoff"})
+	if err != nil {
+		return 0, err
+	}
+	minutes, err := parseIntProp(res.Result["delayoff"])
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse delayoff %s", err)
+	}
+	return time.Duration(minutes) * time.Minute, nil
+}
+
+// DelayOff returns sleep timer time reported by props notification, false when delayoff did not change
+func (n *Notification) DelayOff() (time.Duration, bool) {
+	value, ok := n.Params["delayoff"]
+	if n.Method != "props" || !ok {
+		return 0, false
+	}
+	minutes, err := parseIntProp(value)
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(minutes) * time.Minute, true
+}
+
+// DelayOffCountdown delivers remaining sleep timer time every second, counting down locally
+// and resetting when the bulb reports new delayoff. Only the latest value is kept for slow consumers.
+// The channel is closed when ctx is done
+func (y *Bulb) DelayOffCountdown(ctx context.Context) (<-chan time.Duration, error) {
+	remaining, err := y.GetDelayOff()
+	if err != nil {
+		return nil, err
+	}
+	l, err := y.ListenFiltered(ctx, "props")
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan time.Duration, 1)
+	go func() {
+		defer close(ch)
+		defer l.Close()
+
+		ticker := time.NewTicker(delayOffTick)
+		defer ticker.Stop()
+
+		deadline := time.Now().Add(remaining)
+		send := func() {
+			left := time.Until(deadline)
+			if left < 0 {
+				left = 0
+			}
+			//replace unread value with the recent one
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- left
+		}
+		send()
+
+		notifications := l.Notifications
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case n, ok := <-notifications:
+				if !ok {
+					//connection is lost, keep counting down locally
+					notifications = nil
+					continue
+				}
+				if d, ok := n.DelayOff(); ok {
+					deadline = time.Now().Add(d)
+					send()
+				}
+			case <-ticker.C:
+				send()
+			}
+		}
+	}()
+
+	return ch, nil
+}
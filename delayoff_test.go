@@ -0,0 +1,80 @@
+package yeelight
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestGetDelayOff(t *testing.T) {
+	f := newFakeBulb(t)
+	f.setProps(map[string]string{"delayoff": "15"})
+	y := f.newBulb()
+
+	d, err := y.GetDelayOff()
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if d != 15*time.Minute {
+		t.Errorf("expected 15m, got %s", d)
+	}
+}
+
+func TestNotificationDelayOff(t *testing.T) {
+	if d, ok := (&Notification{Method: "props", Params: map[string]string{"delayoff": "3"}}).DelayOff(); !ok || d != 3*time.Minute {
+		t.Errorf("expected 3m, got %s %t", d, ok)
+	}
+	if _, ok := (&Notification{Method: "props", Params: map[string]string{"power": "on"}}).DelayOff(); ok {
+		t.Error("expected no delayoff in notification without it")
+	}
+}
+
+// nextRemaining waits for countdown value
+func nextRemaining(t *testing.T, ch <-chan time.Duration) time.Duration {
+	t.Helper()
+	select {
+	case d, ok := <-ch:
+		if !ok {
+			t.Fatal("countdown channel was closed")
+		}
+		return d
+	case <-time.After(waitTimeout):
+		t.Fatal("no countdown value delivered")
+	}
+	return 0
+}
+
+func TestDelayOffCountdownTicksAndResets(t *testing.T) {
+	f := newFakeBulb(t)
+	f.setProps(map[string]string{"delayoff": "5"})
+	y := f.newBulb()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch, err := y.DelayOffCountdown(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if d := nextRemaining(t, ch); d > 5*time.Minute || d < 5*time.Minute-time.Second {
+		t.Fatalf("expected about 5m at start, got %s", d)
+	}
+
+	// the listener is the only open connection once get_prop connection is closed
+	eventually(t, "listener connected", func() bool {
+		return f.acceptedConns() == 2 && f.openConns() == 1
+	})
+	f.push(propsLine(map[string]string{"delayoff": "2"}))
+	var reset time.Duration
+	eventually(t, "countdown reset to 2m", func() bool {
+		reset = nextRemaining(t, ch)
+		return reset <= 2*time.Minute
+	})
+
+	if d := nextRemaining(t, ch); d >= reset || d < 2*time.Minute-2*delayOffTick {
+		t.Errorf("expected countdown to tick down from %s, got %s", reset, d)
+	}
+
+	cancel()
+	for range ch {
+	}
+}
@@ -0,0 +1,341 @@
+package yeelight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	// ErrNoDevicesFound is returned when no bulb answered before timeout
+	ErrNoDevicesFound = errors.New("no devices found")
+	// ErrDiscoveryFailed is returned when discovery could not be done because of network failure
+	ErrDiscoveryFailed = errors.New("discovery failed")
+)
+
+// discoveryError wraps network failure, it matches both ErrDiscoveryFailed and the cause
+type discoveryError struct {
+	err error
+}
+
+func discoveryFailed(err error) error {
+	return &discoveryError{err: err}
+}
+
+func (e *discoveryError) Error() string {
+	return fmt.Sprintf("%s. %s", ErrDiscoveryFailed, e.err)
+}
+
+func (e *discoveryError) Is(target error) bool {
+	return target == ErrDiscoveryFailed
+}
+
+func (e *discoveryError) Unwrap() error {
+	return e.err
+}
+
+// readFailed converts error of reading answers, timeout means nothing was found
+func readFailed(err error) error {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrNoDevicesFound
+	}
+	return discoveryFailed(err)
+}
+
+// listenPacket, listInterfaces, interfaceAddrs and searchAddr are replaceable for tests
+var (
+	// searchAddr is where M-SEARCH is sent, the SSDP multicast group
+	searchAddr     = ssdpAddr
+	listenPacket   = net.ListenPacket
+	listInterfaces = net.Interfaces
+	interfaceAddrs = func(i net.Interface) ([]net.Addr, error) {
+		return i.Addrs()
+	}
+)
+
+// DiscoverInterfaces returns interfaces usable for discovery: up, multicast capable and having IPv4 address
+func DiscoverInterfaces() ([]net.Interface, error) {
+	interfaces, err := listInterfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	var usable []net.Interface
+	for _, i := range interfaces {
+		if i.Flags&net.FlagUp == 0 || i.Flags&net.FlagMulticast == 0 {
+			continue
+		}
+		addrs, err := interfaceAddrs(i)
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+				usable = append(usable, i)
+				break
+			}
+		}
+	}
+	return usable, nil
+}
+
+// answerSource yields discovery answers
+type answerSource interface {
+	// next returns next answer received before deadline or nil when deadline is reached
+	next(deadline time.Time) (*YeelightParams, error)
+	close()
+}
+
+// openSearch sends M-SEARCH and returns source of answers. Tests replace it
+// to simulate SSDP answers without real multicast
+var openSearch = func(opts DiscoverOptions, msg string) (answerSource, error) {
+	s, err := newSearcher(opts, msg)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// defaultRetryGap is interval between M-SEARCH retries
+const defaultRetryGap = 300 * time.Millisecond
+
+// searcher sends M-SEARCH and reads answers of the bulbs
+type searcher struct {
+	socket *net.UDPConn
+	buf    []byte
+	done   chan struct{}
+}
+
+// newSearcher opens discovery socket and sends msg, the socket has to be closed with close.
+// The socket does not join the multicast group and binds random port unless SourcePort is set,
+// so concurrent discoveries do not conflict. Busy SourcePort is reported as discovery error
+func newSearcher(opts DiscoverOptions, msg string) (*searcher, error) {
+	ssdp, _ := net.ResolveUDPAddr("udp4", searchAddr)
+	c, err := listenPacket("udp4", fmt.Sprintf(":%d", opts.SourcePort))
+	if err != nil {
+		if errors.Is(err, syscall.EADDRINUSE) {
+			return nil, discoveryFailed(fmt.Errorf("source port %d is already in use", opts.SourcePort))
+		}
+		return nil, discoveryFailed(err)
+	}
+	socket, ok := c.(*net.UDPConn)
+	if !ok {
+		c.Close()
+		return nil, discoveryFailed(fmt.Errorf("unexpected connection type %T", c))
+	}
+	if err := applyMulticastOptions(socket, opts); err != nil {
+		socket.Close()
+		return nil, discoveryFailed(err)
+	}
+	if _, err := socket.WriteToUDP([]byte(msg), ssdp); err != nil {
+		socket.Close()
+		return nil, discoveryFailed(err)
+	}
+
+	s := &searcher{socket: socket, buf: make([]byte, 1024), done: make(chan struct{})}
+	if opts.Retries > 0 {
+		gap := opts.RetryGap
+		if gap == 0 {
+			gap = defaultRetryGap
+		}
+		go s.resend([]byte(msg), ssdp, opts.Retries, gap)
+	}
+	return s, nil
+}
+
+// resend repeats M-SEARCH until it is sent given number of times or searcher is closed.
+// Bulbs may answer each of them, callers deduplicate answers
+func (s *searcher) resend(msg []byte, addr *net.UDPAddr, times int, gap time.Duration) {
+	ticker := time.NewTicker(gap)
+	defer ticker.Stop()
+
+	for i := 0; i < times; i++ {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+		if _, err := s.socket.WriteToUDP(msg, addr); err != nil {
+			return
+		}
+	}
+}
+
+func (s *searcher) next(deadline time.Time) (*YeelightParams, error) {
+	s.socket.SetReadDeadline(deadline)
+	size, _, err := s.socket.ReadFromUDP(s.buf)
+	if err != nil {
+		if err := readFailed(err); err != ErrNoDevicesFound {
+			return nil, err
+		}
+		return nil, nil
+	}
+	return parseAnswer(string(s.buf[:size])), nil
+}
+
+func (s *searcher) close() {
+	close(s.done)
+	s.socket.Close()
+}
+
+// DiscoverFirst collects answers for at least minWait and returns the first valid bulb.
+// If no valid bulb answered within minWait, it waits for one up to maxWait
+func DiscoverFirst(minWait, maxWait time.Duration) (*Bulb, error) {
+	s, err := openSearch(DiscoverOptions{}, discoverMSG)
+	if err != nil {
+		return nil, err
+	}
+	defer s.close()
+
+	start := time.Now()
+	var first *YeelightParams
+	for first == nil || time.Since(start) < minWait {
+		deadline := start.Add(maxWait)
+		if first != nil {
+			deadline = start.Add(minWait)
+		}
+		p, err := s.next(deadline)
+		if err != nil {
+			return nil, err
+		}
+		if p == nil {
+			break
+		}
+		if first == nil && p.valid() {
+			first = p
+		}
+	}
+	if first == nil {
+		return nil, ErrNoDevicesFound
+	}
+
+	return newDiscoveredBulb(first), nil
+}
+
+// DiscoverAll collects answers until timeout and returns all valid bulbs, each once, sorted by id
+func DiscoverAll(opts DiscoverOptions) ([]*Bulb, error) {
+	s, err := openSearch(opts, discoverMSG)
+	if err != nil {
+		return nil, err
+	}
+	defer s.close()
+
+	wait := opts.Timeout
+	if wait == 0 {
+		wait = timeout
+	}
+	deadline := time.Now().Add(wait)
+
+	var bulbs []*Bulb
+	seen := make(map[string]bool)
+	for {
+		p, err := s.next(deadline)
+		if err != nil {
+			return nil, err
+		}
+		if p == nil {
+			break
+		}
+		if !p.valid() || seen[p.Key()] || !p.inSubnet(opts.SubnetFilter) {
+			continue
+		}
+		seen[p.Key()] = true
+		bulbs = append(bulbs, newDiscoveredBulb(p))
+	}
+
+	if !opts.ArrivalOrder {
+		sort.Slice(bulbs, func(i, j int) bool {
+			return bulbs[i].ID() < bulbs[j].ID()
+		})
+	}
+	return bulbs, nil
+}
+
+// DiscoverByName discovers all bulbs and returns the one with given name.
+// Name from discovery answer is used when present, otherwise it is read from the bulb
+func DiscoverByName(name string, timeout time.Duration) (*Bulb, error) {
+	bulbs, err := DiscoverAll(DiscoverOptions{Timeout: timeout})
+	if err != nil {
+		return nil, err
+	}
+
+	var found []*Bulb
+	for _, y := range bulbs {
+		bulbName := y.Name()
+		if bulbName == "" {
+			res, err := y.GetProps([]string{"name"})
+			if err != nil {
+				continue
+			}
+			bulbName = res.Result["name"]
+		}
+		if bulbName == name {
+			found = append(found, y)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("no bulb named %q found", name)
+	case 1:
+		return found[0], nil
+	default:
+		var candidates []string
+		for _, y := range found {
+			candidates = append(candidates, y.address())
+		}
+		return nil, fmt.Errorf("%d bulbs named %q found: %s", len(found), name, strings.Join(candidates, ", "))
+	}
+}
+
+// DiscoverAndConnect discovers the first bulb and opens keep-alive connection to it.
+// The connection is verified by reading power state, so the returned bulb is ready to use
+func DiscoverAndConnect(ctx context.Context) (*Bulb, error) {
+	s, err := openSearch(DiscoverOptions{}, discoverMSG)
+	if err != nil {
+		return nil, err
+	}
+	defer s.close()
+
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	var params *YeelightParams
+	for params == nil {
+		p, err := s.next(deadline)
+		if err != nil {
+			return nil, err
+		}
+		if p == nil {
+			return nil, ErrNoDevicesFound
+		}
+		if p.valid() {
+			params = p
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	y := newDiscoveredBulb(params, WithKeepAlive(true))
+	if _, err := y.GetProps([]string{"power"}); err != nil {
+		y.Close()
+		return nil, fmt.Errorf("cannot connect to discovered bulb %s. %w", y.address(), err)
+	}
+	return y, nil
+}
+
+// newDiscoveredBulb creates bulb from discovery answer
+func newDiscoveredBulb(p *YeelightParams, opts ...Option) *Bulb {
+	ip, port, _ := p.hostPort()
+	y := NewWithOptions(ip, append([]Option{WithPort(port)}, opts...)...)
+	y.params = p
+	return y
+}
@@ -0,0 +1,656 @@
+package yeelight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"golang.org/x/net/ipv4"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestApplyMulticastOptions(t *testing.T) {
+	socket, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("cannot open socket %s", err)
+	}
+	defer socket.Close()
+
+	if err := applyMulticastOptions(socket, DiscoverOptions{LoopbackDisabled: true, TTL: 3}); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	p := ipv4.NewPacketConn(socket)
+	if loopback, err := p.MulticastLoopback(); err != nil || loopback {
+		t.Errorf("expected loopback disabled, got %t %v", loopback, err)
+	}
+	if ttl, err := p.MulticastTTL(); err != nil || ttl != 3 {
+		t.Errorf("expected TTL 3, got %d %v", ttl, err)
+	}
+}
+
+func TestApplyMulticastOptionsKeepsDefaults(t *testing.T) {
+	socket, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("cannot open socket %s", err)
+	}
+	defer socket.Close()
+
+	p := ipv4.NewPacketConn(socket)
+	loopback, _ := p.MulticastLoopback()
+	ttl, _ := p.MulticastTTL()
+
+	if err := applyMulticastOptions(socket, DiscoverOptions{}); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if got, _ := p.MulticastLoopback(); got != loopback {
+		t.Errorf("expected loopback %t to be kept, got %t", loopback, got)
+	}
+	if got, _ := p.MulticastTTL(); got != ttl {
+		t.Errorf("expected TTL %d to be kept, got %d", ttl, got)
+	}
+}
+
+func TestDiscoverFirstReturnsFirstValidBulb(t *testing.T) {
+	fakeDiscovery(t,
+		&YeelightParams{Location: "yeelight://127.0.0.1:55443"},
+		answer("0x1", 55443),
+		answer("0x1", 55443),
+		answer("0x2", 55444),
+	)
+
+	y, err := DiscoverFirst(10*time.Millisecond, 100*time.Millisecond)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if y.ID() != "0x1" {
+		t.Errorf("expected bulb 0x1, got %q", y.ID())
+	}
+}
+
+func TestDiscoverFirstWithoutValidAnswer(t *testing.T) {
+	fakeDiscovery(t, &YeelightParams{ID: "0x1", Location: "http://127.0.0.1"})
+
+	if _, err := DiscoverFirst(10*time.Millisecond, 100*time.Millisecond); err != ErrNoDevicesFound {
+		t.Fatalf("expected ErrNoDevicesFound, got %v", err)
+	}
+}
+
+func TestDiscoverByName(t *testing.T) {
+	kitchen := newFakeBulb(t)
+	kitchen.setProps(map[string]string{"name": "kitchen"})
+	bedroom := answer("0x2", newFakeBulb(t).port())
+	bedroom.Name = "bedroom"
+	fakeDiscovery(t, answer("0x1", kitchen.port()), bedroom)
+
+	y, err := DiscoverByName("kitchen", 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if y.ID() != "0x1" {
+		t.Errorf("expected bulb 0x1 named by get_prop, got %q", y.ID())
+	}
+
+	y, err = DiscoverByName("bedroom", 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if y.ID() != "0x2" {
+		t.Errorf("expected bulb 0x2 named in discovery answer, got %q", y.ID())
+	}
+}
+
+func TestDiscoverByNameReportsDuplicates(t *testing.T) {
+	first, second := answer("0x1", 55443), answer("0x2", 55444)
+	first.Name, second.Name = "lamp", "lamp"
+	fakeDiscovery(t, first, second)
+
+	_, err := DiscoverByName("lamp", 10*time.Millisecond)
+	if err == nil {
+		t.Fatal("expected error for duplicate names")
+	}
+	if !strings.Contains(err.Error(), "127.0.0.1:55443") || !strings.Contains(err.Error(), "127.0.0.1:55444") {
+		t.Errorf("expected candidates in error, got %q", err)
+	}
+}
+
+func TestDiscoverByNameNotFound(t *testing.T) {
+	named := answer("0x1", 55443)
+	named.Name = "kitchen"
+	fakeDiscovery(t, named)
+
+	if _, err := DiscoverByName("garage", 10*time.Millisecond); err == nil {
+		t.Fatal("expected error for unknown name")
+	}
+}
+
+func TestSearchMessagesAreWellFormed(t *testing.T) {
+	want := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: 239.255.255.250:1982\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 3\r\n" +
+		"ST: wifi_bulb\r\n" +
+		"\r\n"
+	if discoverMSG != want {
+		t.Errorf("unexpected discover message %q", discoverMSG)
+	}
+	if got := fmt.Sprintf(searchMSG, ssdpAddr); got != want {
+		t.Errorf("unexpected per-host message %q", got)
+	}
+}
+
+func TestBulbDiscoverSendsMessageToBulbHost(t *testing.T) {
+	s := fakeDiscovery(t, answer("0x1", 55443))
+	y := NewWithOptions("192.168.1.10")
+
+	if _, err := y.Discover(); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if len(s.msgs) != 1 || !strings.Contains(s.msgs[0], "\r\nHOST: 192.168.1.10:1982\r\n") {
+		t.Errorf("expected M-SEARCH with bulb host, got %q", s.msgs)
+	}
+}
+
+// timeoutError is net.Error reporting timeout
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestReadFailedDistinguishesTimeout(t *testing.T) {
+	if err := readFailed(timeoutError{}); err != ErrNoDevicesFound {
+		t.Errorf("expected ErrNoDevicesFound for timeout, got %v", err)
+	}
+
+	cause := errors.New("network is unreachable")
+	err := readFailed(cause)
+	if !errors.Is(err, ErrDiscoveryFailed) || !errors.Is(err, cause) {
+		t.Errorf("expected ErrDiscoveryFailed wrapping the cause, got %v", err)
+	}
+	if errors.Is(err, ErrNoDevicesFound) {
+		t.Error("network failure must not match ErrNoDevicesFound")
+	}
+}
+
+func TestDiscoverReportsSocketFailure(t *testing.T) {
+	cause := errors.New("permission denied")
+	original := listenPacket
+	listenPacket = func(network, address string) (net.PacketConn, error) {
+		return nil, cause
+	}
+	defer func() { listenPacket = original }()
+
+	_, err := Discover()
+	if !errors.Is(err, ErrDiscoveryFailed) || !errors.Is(err, cause) {
+		t.Fatalf("expected ErrDiscoveryFailed wrapping the cause, got %v", err)
+	}
+}
+
+func TestDiscoverReportsNoDevices(t *testing.T) {
+	fakeDiscovery(t)
+
+	if _, err := Discover(); err != ErrNoDevicesFound {
+		t.Fatalf("expected ErrNoDevicesFound, got %v", err)
+	}
+}
+
+func TestDiscoverInterfacesReturnsOnlyEligible(t *testing.T) {
+	up := net.FlagUp | net.FlagMulticast
+	interfaces := []net.Interface{
+		{Index: 1, Name: "eth0", Flags: up},
+		{Index: 2, Name: "lo", Flags: net.FlagUp | net.FlagLoopback},
+		{Index: 3, Name: "wlan0", Flags: net.FlagMulticast},
+		{Index: 4, Name: "v6only", Flags: up},
+		{Index: 5, Name: "broken", Flags: up},
+	}
+	addrs := map[string][]net.Addr{
+		"eth0":   {&net.IPNet{IP: net.ParseIP("fe80::1")}, &net.IPNet{IP: net.IPv4(192, 168, 1, 2)}},
+		"lo":     {&net.IPNet{IP: net.IPv4(127, 0, 0, 1)}},
+		"wlan0":  {&net.IPNet{IP: net.IPv4(192, 168, 2, 2)}},
+		"v6only": {&net.IPNet{IP: net.ParseIP("fe80::2")}},
+	}
+	originalList, originalAddrs := listInterfaces, interfaceAddrs
+	listInterfaces = func() ([]net.Interface, error) { return interfaces, nil }
+	interfaceAddrs = func(i net.Interface) ([]net.Addr, error) {
+		if i.Name == "broken" {
+			return nil, errors.New("no addresses")
+		}
+		return addrs[i.Name], nil
+	}
+	defer func() { listInterfaces, interfaceAddrs = originalList, originalAddrs }()
+
+	usable, err := DiscoverInterfaces()
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if len(usable) != 1 || usable[0].Name != "eth0" {
+		t.Fatalf("expected only eth0, got %v", usable)
+	}
+}
+
+func TestDiscoverInterfacesReportsListError(t *testing.T) {
+	original := listInterfaces
+	listInterfaces = func() ([]net.Interface, error) { return nil, errors.New("no interfaces") }
+	defer func() { listInterfaces = original }()
+
+	if _, err := DiscoverInterfaces(); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestDiscoveryClosesSearchOnEveryPath(t *testing.T) {
+	s := fakeDiscovery(t)
+	bulb := NewWithOptions("127.0.0.1")
+
+	for i := 0; i < 20; i++ {
+		Discover()
+		DiscoverAll(DiscoverOptions{})
+		DiscoverFirst(0, 0)
+		bulb.Discover()
+	}
+	if open := s.openSearches(); open != 0 {
+		t.Fatalf("expected all searches closed, %d left open", open)
+	}
+}
+
+// closeRecorder is packet connection which is not *net.UDPConn and remembers whether it was closed
+type closeRecorder struct {
+	net.PacketConn
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return c.PacketConn.Close()
+}
+
+func TestDiscoveryClosesSocketOfUnexpectedType(t *testing.T) {
+	var recorder *closeRecorder
+	original := listenPacket
+	listenPacket = func(network, address string) (net.PacketConn, error) {
+		c, err := original(network, address)
+		if err != nil {
+			return nil, err
+		}
+		recorder = &closeRecorder{PacketConn: c}
+		return recorder, nil
+	}
+	defer func() { listenPacket = original }()
+
+	if _, err := DiscoverAll(DiscoverOptions{Timeout: 10 * time.Millisecond}); !errors.Is(err, ErrDiscoveryFailed) {
+		t.Fatalf("expected ErrDiscoveryFailed, got %v", err)
+	}
+	if recorder == nil || !recorder.closed {
+		t.Fatal("socket was not closed")
+	}
+}
+
+func TestDiscoveryClosesSocketOnTimeout(t *testing.T) {
+	var sockets []net.PacketConn
+	original := listenPacket
+	listenPacket = func(network, address string) (net.PacketConn, error) {
+		c, err := original(network, address)
+		if err == nil {
+			sockets = append(sockets, c)
+		}
+		return c, err
+	}
+	defer func() { listenPacket = original }()
+
+	for i := 0; i < 5; i++ {
+		DiscoverAll(DiscoverOptions{Timeout: 10 * time.Millisecond, LoopbackDisabled: true})
+	}
+	if len(sockets) != 5 {
+		t.Fatalf("expected 5 sockets, got %d", len(sockets))
+	}
+	for _, socket := range sockets {
+		if err := socket.SetReadDeadline(time.Now()); err == nil {
+			t.Fatal("socket was left open")
+		}
+	}
+}
+
+// freeUDPPort returns local UDP port which was free a moment ago
+func freeUDPPort(t *testing.T) int {
+	c, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		t.Fatalf("cannot find free port %s", err)
+	}
+	defer c.Close()
+	return c.LocalAddr().(*net.UDPAddr).Port
+}
+
+func TestDiscoveryBindsSourcePort(t *testing.T) {
+	port := freeUDPPort(t)
+	var bound int
+	original := listenPacket
+	listenPacket = func(network, address string) (net.PacketConn, error) {
+		c, err := original(network, address)
+		if err == nil {
+			bound = c.LocalAddr().(*net.UDPAddr).Port
+		}
+		return c, err
+	}
+	defer func() { listenPacket = original }()
+
+	DiscoverAll(DiscoverOptions{SourcePort: port, Timeout: 10 * time.Millisecond, LoopbackDisabled: true})
+	if bound != port {
+		t.Fatalf("expected socket bound to port %d, got %d", port, bound)
+	}
+}
+
+func TestDiscoveryReportsBusySourcePort(t *testing.T) {
+	busy, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		t.Fatalf("cannot open socket %s", err)
+	}
+	defer busy.Close()
+	port := busy.LocalAddr().(*net.UDPAddr).Port
+
+	_, err = DiscoverAll(DiscoverOptions{SourcePort: port, Timeout: 10 * time.Millisecond})
+	if !errors.Is(err, ErrDiscoveryFailed) || !strings.Contains(err.Error(), "already in use") {
+		t.Fatalf("expected busy port error, got %v", err)
+	}
+}
+
+func TestDiscoverReturnsCannedAnswer(t *testing.T) {
+	canned := answer("0x1", 55443)
+	canned.Model = "color"
+	canned.Name = "desk"
+	s := fakeDiscovery(t, canned)
+
+	y, err := DiscoverWithOptions(DiscoverOptions{TTL: 2})
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if y.ID() != "0x1" || y.host() != "127.0.0.1" || y.address() != "127.0.0.1:55443" {
+		t.Errorf("unexpected bulb %s at %s", y.ID(), y.address())
+	}
+	if p := y.discovery(); p == nil || p.Name != "desk" || p.Model != "color" {
+		t.Errorf("expected canned params kept by the bulb, got %+v", p)
+	}
+	if len(s.opts) != 1 || s.opts[0].TTL != 2 || s.msgs[0] != discoverMSG {
+		t.Errorf("search was not opened with given options, got %+v %q", s.opts, s.msgs)
+	}
+}
+
+func TestDiscoverSkipsInvalidAnswer(t *testing.T) {
+	fakeDiscovery(t, &YeelightParams{ID: "0x1"}, answer("0x2", 55443))
+
+	y, err := Discover()
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if y.ID() != "0x2" {
+		t.Errorf("expected the first valid bulb, got %s", y.ID())
+	}
+}
+
+func TestDiscoverAndConnectReturnsConnectedBulb(t *testing.T) {
+	f := newFakeBulb(t)
+	fakeDiscovery(t, answer("0x1", f.port()))
+
+	y, err := DiscoverAndConnect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	defer y.Close()
+	if y.ID() != "0x1" || y.ConnState() != Connected {
+		t.Errorf("expected connected bulb 0x1, got %q %s", y.ID(), y.ConnState())
+	}
+	if _, err := y.TurnOn(); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if got := f.acceptedConns(); got != 1 {
+		t.Errorf("expected the verified connection to be kept, got %d connections", got)
+	}
+}
+
+func TestDiscoverAndConnectReportsConnectFailure(t *testing.T) {
+	fakeDiscovery(t, answer("0x1", refusedPort(t)))
+
+	_, err := DiscoverAndConnect(context.Background())
+	if !errors.Is(err, ErrLANControlDisabled) || !strings.Contains(err.Error(), "discovered bulb") {
+		t.Fatalf("expected connect error, got %v", err)
+	}
+}
+
+func TestDiscoverAndConnectWithoutBulbs(t *testing.T) {
+	fakeDiscovery(t)
+
+	if _, err := DiscoverAndConnect(context.Background()); err != ErrNoDevicesFound {
+		t.Fatalf("expected ErrNoDevicesFound, got %v", err)
+	}
+}
+
+func TestDiscoverAllFiltersSubnet(t *testing.T) {
+	fakeDiscovery(t,
+		&YeelightParams{ID: "0x1", Location: "yeelight://192.168.1.10:55443"},
+		&YeelightParams{ID: "0x2", Location: "yeelight://10.0.0.10:55443"},
+		&YeelightParams{ID: "0x3", Location: "yeelight://192.168.1.11:55443"},
+	)
+	_, subnet, _ := net.ParseCIDR("192.168.1.0/24")
+
+	bulbs, err := DiscoverAll(DiscoverOptions{SubnetFilter: subnet})
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if len(bulbs) != 2 || bulbs[0].ID() != "0x1" || bulbs[1].ID() != "0x3" {
+		t.Fatalf("expected only bulbs in 192.168.1.0/24, got %d bulbs", len(bulbs))
+	}
+
+	all, err := DiscoverAll(DiscoverOptions{})
+	if err != nil || len(all) != 3 {
+		t.Errorf("expected all bulbs without filter, got %d %v", len(all), err)
+	}
+}
+
+func TestDiscoverAllOrder(t *testing.T) {
+	answers := []*YeelightParams{answer("0x3", 55443), answer("0x1", 55444), answer("0x2", 55445)}
+	tests := []struct {
+		arrival bool
+		want    []string
+	}{
+		{false, []string{"0x1", "0x2", "0x3"}},
+		{true, []string{"0x3", "0x1", "0x2"}},
+	}
+	for _, tt := range tests {
+		fakeDiscovery(t, answers...)
+
+		bulbs, err := DiscoverAll(DiscoverOptions{ArrivalOrder: tt.arrival})
+		if err != nil {
+			t.Fatalf("unexpected error %s", err)
+		}
+		var got []string
+		for _, y := range bulbs {
+			got = append(got, y.ID())
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("arrival order %t returned %v, want %v", tt.arrival, got, tt.want)
+		}
+	}
+}
+
+// udpSink counts datagrams received on a local UDP port
+func udpSink(t *testing.T) (*net.UDPAddr, func() int) {
+	c, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("cannot open socket %s", err)
+	}
+	t.Cleanup(func() { c.Close() })
+
+	var mu sync.Mutex
+	count := 0
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			if _, _, err := c.ReadFromUDP(buf); err != nil {
+				return
+			}
+			mu.Lock()
+			count++
+			mu.Unlock()
+		}
+	}()
+	return c.LocalAddr().(*net.UDPAddr), func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return count
+	}
+}
+
+func TestSearcherResendsSearch(t *testing.T) {
+	addr, received := udpSink(t)
+	socket, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("cannot open socket %s", err)
+	}
+	s := &searcher{socket: socket, done: make(chan struct{})}
+	defer s.close()
+
+	s.resend([]byte(discoverMSG), addr, 3, 10*time.Millisecond)
+	eventually(t, "3 retries received", func() bool {
+		return received() == 3
+	})
+}
+
+func TestSearcherStopsResendingWhenClosed(t *testing.T) {
+	addr, received := udpSink(t)
+	socket, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("cannot open socket %s", err)
+	}
+	s := &searcher{socket: socket, done: make(chan struct{})}
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		s.resend([]byte(discoverMSG), addr, 1000, 10*time.Millisecond)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	s.close()
+
+	select {
+	case <-stopped:
+	case <-time.After(waitTimeout):
+		t.Fatal("resending did not stop after close")
+	}
+	if got := received(); got >= 1000 {
+		t.Errorf("expected resending to stop early, got %d datagrams", got)
+	}
+}
+
+func TestDiscoverAllWithRetriesCollapsesDuplicates(t *testing.T) {
+	s := fakeDiscovery(t, answer("0x1", 55443), answer("0x1", 55443), answer("0x2", 55444), answer("0x1", 55443))
+
+	bulbs, err := DiscoverAll(DiscoverOptions{Retries: 2, RetryGap: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if len(bulbs) != 2 {
+		t.Errorf("expected answers to retries collapsed to 2 bulbs, got %d", len(bulbs))
+	}
+	if len(s.opts) != 1 || s.opts[0].Retries != 2 {
+		t.Errorf("expected retries passed to the search, got %+v", s.opts)
+	}
+}
+
+// ssdpResponder answers M-SEARCH requests sent to a loopback port with answer of bulb 0x1 at port,
+// it is set as search address for the test
+func ssdpResponder(t *testing.T, port int) {
+	c, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("cannot open socket %s", err)
+	}
+	original := searchAddr
+	searchAddr = c.LocalAddr().String()
+	t.Cleanup(func() {
+		searchAddr = original
+		c.Close()
+	})
+
+	answer := strings.Join([]string{
+		"HTTP/1.1 200 OK",
+		"Cache-Control: max-age=3600",
+		fmt.Sprintf("Location: yeelight://127.0.0.1:%d", port),
+		"id: 0x1",
+		"model: color",
+		"support: get_prop set_power",
+	}, crlf) + crlf
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			size, from, err := c.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if strings.HasPrefix(string(buf[:size]), "M-SEARCH") {
+				c.WriteToUDP([]byte(answer), from)
+			}
+		}
+	}()
+}
+
+func TestConcurrentDiscoveriesAndListenDoNotConflict(t *testing.T) {
+	f := newFakeBulb(t)
+	ssdpResponder(t, f.port())
+	var mu sync.Mutex
+	ports := make(map[int]bool)
+	original := listenPacket
+	listenPacket = func(network, address string) (net.PacketConn, error) {
+		c, err := original(network, address)
+		if err == nil {
+			mu.Lock()
+			ports[c.LocalAddr().(*net.UDPAddr).Port] = true
+			mu.Unlock()
+		}
+		return c, err
+	}
+	defer func() { listenPacket = original }()
+
+	l, err := f.newBulb().NewListener()
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	defer l.Close()
+	f.waitConns(1)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			bulbs, err := DiscoverAll(DiscoverOptions{Timeout: 100 * time.Millisecond})
+			if err == nil && (len(bulbs) != 1 || bulbs[0].ID() != "0x1") {
+				err = fmt.Errorf("expected bulb 0x1, got %d bulbs", len(bulbs))
+			}
+			errs <- err
+		}()
+	}
+	// notifications keep coming while discoveries run
+	f.push(propsLine(map[string]string{"bright": "20"}))
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("concurrent discovery failed, %s", err)
+		}
+	}
+	if len(ports) != 4 {
+		t.Errorf("expected 4 distinct source ports, got %d", len(ports))
+	}
+	if n := nextNotification(t, l); n.Params["bright"] != "20" {
+		t.Errorf("expected notification delivered during discovery, got %+v", n)
+	}
+	select {
+	case err := <-l.Errors:
+		t.Errorf("listener failed during discovery, %s", err)
+	default:
+	}
+}
@@ -0,0 +1,492 @@
+package yeelight
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// quietLogger swallows warnings of bulbs created by tests
+var quietLogger = log.New(ioutil.Discard, "", 0)
+
+// waitTimeout limits how long tests wait for something to happen
+const waitTimeout = 2 * time.Second
+
+// fakeBulb is TCP server answering commands like a bulb. By default set commands get "ok"
+// and get_prop gets values of props set with setProps
+type fakeBulb struct {
+	t        *testing.T
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu        sync.Mutex
+	commands  []Command
+	conns     map[net.Conn]bool
+	accepted  int
+	maxActive int
+	props     map[string]string
+	handler   func(cmd Command) []string
+	onAccept  func(conn net.Conn) bool
+	// music receives commands sent over music connections
+	music []Command
+}
+
+// newFakeBulb starts fake bulb on a random local port, it is closed when the test ends
+func newFakeBulb(t *testing.T) *fakeBulb {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot start fake bulb %s", err)
+	}
+	f := &fakeBulb{
+		t:        t,
+		listener: listener,
+		conns:    make(map[net.Conn]bool),
+		props:    map[string]string{"power": "on"},
+	}
+	f.wg.Add(1)
+	go f.serve()
+	t.Cleanup(f.close)
+	return f
+}
+
+// port returns port the fake bulb listens on
+func (f *fakeBulb) port() int {
+	return f.listener.Addr().(*net.TCPAddr).Port
+}
+
+// newBulb creates bulb connecting to the fake one
+func (f *fakeBulb) newBulb(opts ...Option) *Bulb {
+	defaults := []Option{WithPort(f.port()), WithTimeout(time.Second), WithLogger(quietLogger)}
+	y := NewWithOptions("127.0.0.1", append(defaults, opts...)...)
+	f.t.Cleanup(func() {
+		y.Close()
+	})
+	return y
+}
+
+// discoveredBulb creates bulb connecting to the fake one as if it was found by discovery
+func (f *fakeBulb) discoveredBulb(p YeelightParams, opts ...Option) *Bulb {
+	p.Location = fmt.Sprintf("yeelight://127.0.0.1:%d", f.port())
+	y := newDiscoveredBulb(&p, append([]Option{WithTimeout(time.Second), WithLogger(quietLogger)}, opts...)...)
+	f.t.Cleanup(func() {
+		y.Close()
+	})
+	return y
+}
+
+// setProps sets values returned by get_prop, unset props are returned empty
+func (f *fakeBulb) setProps(props map[string]string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for key, value := range props {
+		f.props[key] = value
+	}
+}
+
+// reply makes handler answer commands, nil answer falls back to the default one
+// and empty answer sends nothing
+func (f *fakeBulb) reply(handler func(cmd Command) []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.handler = handler
+}
+
+// enableMusic makes the fake bulb connect to music server requested by set_music
+func (f *fakeBulb) enableMusic() {
+	f.reply(func(cmd Command) []string {
+		if cmd.Method == "set_music" && propString(cmd.Params[0]) == "1" {
+			addr := net.JoinHostPort(propString(cmd.Params[1]), propString(cmd.Params[2]))
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				f.t.Errorf("fake bulb cannot connect to music server %s", err)
+				return nil
+			}
+			f.mu.Lock()
+			f.conns[conn] = true
+			f.mu.Unlock()
+			f.wg.Add(1)
+			go f.readMusic(conn)
+		}
+		return nil
+	})
+}
+
+// readMusic records commands received over music connection, the bulb does not answer them
+func (f *fakeBulb) readMusic(conn net.Conn) {
+	defer f.wg.Done()
+	defer func() {
+		f.mu.Lock()
+		delete(f.conns, conn)
+		f.mu.Unlock()
+		conn.Close()
+	}()
+
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		var cmd Command
+		if err := json.Unmarshal([]byte(strings.TrimSpace(line)), &cmd); err != nil {
+			f.t.Errorf("fake bulb received malformed music command %q", line)
+			return
+		}
+		f.mu.Lock()
+		f.music = append(f.music, cmd)
+		f.mu.Unlock()
+	}
+}
+
+// musicCommands returns commands received over music connections so far
+func (f *fakeBulb) musicCommands() []Command {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]Command(nil), f.music...)
+}
+
+// waitMusic waits until at least n music commands are received and returns them
+func (f *fakeBulb) waitMusic(n int) []Command {
+	f.t.Helper()
+	eventually(f.t, fmt.Sprintf("%d music commands received", n), func() bool {
+		return len(f.musicCommands()) >= n
+	})
+	return f.musicCommands()
+}
+
+// acceptWith calls hook for every accepted connection, false makes the connection be closed at once
+func (f *fakeBulb) acceptWith(hook func(conn net.Conn) bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.onAccept = hook
+}
+
+// received returns commands received so far
+func (f *fakeBulb) received() []Command {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]Command(nil), f.commands...)
+}
+
+// methods returns methods of commands received so far
+func (f *fakeBulb) methods() []string {
+	var methods []string
+	for _, cmd := range f.received() {
+		methods = append(methods, cmd.Method)
+	}
+	return methods
+}
+
+// last returns the latest received command with method, it fails the test when there is none
+func (f *fakeBulb) last(method string) Command {
+	f.t.Helper()
+	commands := f.received()
+	for i := len(commands) - 1; i >= 0; i-- {
+		if commands[i].Method == method {
+			return commands[i]
+		}
+	}
+	f.t.Fatalf("%s was not received, got %v", method, f.methods())
+	return Command{}
+}
+
+// waitCommands waits until at least n commands are received and returns them
+func (f *fakeBulb) waitCommands(n int) []Command {
+	f.t.Helper()
+	eventually(f.t, fmt.Sprintf("%d commands received", n), func() bool {
+		return len(f.received()) >= n
+	})
+	return f.received()
+}
+
+// openConns returns number of currently open connections
+func (f *fakeBulb) openConns() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.conns)
+}
+
+// waitConns waits until n connections are open
+func (f *fakeBulb) waitConns(n int) {
+	f.t.Helper()
+	eventually(f.t, fmt.Sprintf("%d open connections", n), func() bool {
+		return f.openConns() == n
+	})
+}
+
+// acceptedConns returns number of connections accepted since start
+func (f *fakeBulb) acceptedConns() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.accepted
+}
+
+// peakConns returns the highest number of connections open at once
+func (f *fakeBulb) peakConns() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.maxActive
+}
+
+// push writes line to all open connections, as the bulb does with notifications
+func (f *fakeBulb) push(line string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for conn := range f.conns {
+		fmt.Fprint(conn, line+crlf)
+	}
+}
+
+// dropConns closes all open connections
+func (f *fakeBulb) dropConns() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for conn := range f.conns {
+		conn.Close()
+	}
+}
+
+func (f *fakeBulb) close() {
+	f.listener.Close()
+	f.dropConns()
+	f.wg.Wait()
+}
+
+func (f *fakeBulb) serve() {
+	defer f.wg.Done()
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		f.mu.Lock()
+		f.accepted++
+		hook := f.onAccept
+		f.mu.Unlock()
+		if hook != nil && !hook(conn) {
+			conn.Close()
+			continue
+		}
+
+		f.mu.Lock()
+		f.conns[conn] = true
+		if len(f.conns) > f.maxActive {
+			f.maxActive = len(f.conns)
+		}
+		f.mu.Unlock()
+		f.wg.Add(1)
+		go f.handle(conn)
+	}
+}
+
+// handle answers commands received on a single connection
+func (f *fakeBulb) handle(conn net.Conn) {
+	defer f.wg.Done()
+	defer func() {
+		f.mu.Lock()
+		delete(f.conns, conn)
+		f.mu.Unlock()
+		conn.Close()
+	}()
+
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		var cmd Command
+		if err := json.Unmarshal([]byte(strings.TrimSpace(line)), &cmd); err != nil {
+			f.t.Errorf("fake bulb received malformed command %q", line)
+			return
+		}
+
+		f.mu.Lock()
+		f.commands = append(f.commands, cmd)
+		handler := f.handler
+		f.mu.Unlock()
+
+		var replies []string
+		if handler != nil {
+			replies = handler(cmd)
+		}
+		if replies == nil {
+			replies = f.defaultReply(cmd)
+		}
+		for _, reply := range replies {
+			f.mu.Lock()
+			_, err := fmt.Fprint(conn, reply+crlf)
+			f.mu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// defaultReply answers get_prop with known props and other commands with ok
+func (f *fakeBulb) defaultReply(cmd Command) []string {
+	if cmd.Method != "get_prop" {
+		return []string{okLine(cmd.ID)}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	values := make([]string, len(cmd.Params))
+	for i, param := range cmd.Params {
+		name, _ := param.(string)
+		values[i] = f.props[name]
+	}
+	return []string{resultLine(cmd.ID, values...)}
+}
+
+// okLine is reply of successful set command
+func okLine(id int) string {
+	return fmt.Sprintf(`{"id":%d,"result":["ok"]}`, id)
+}
+
+// resultLine is reply with given result values
+func resultLine(id int, values ...string) string {
+	b, _ := json.Marshal(map[string]interface{}{"id": id, "result": values})
+	return string(b)
+}
+
+// errorLine is reply of rejected command
+func errorLine(id int, code int, message string) string {
+	return fmt.Sprintf(`{"id":%d,"error":{"code":%d,"message":%q}}`, id, code, message)
+}
+
+// propsLine is props notification
+func propsLine(props map[string]string) string {
+	b, _ := json.Marshal(map[string]interface{}{"method": "props", "params": props})
+	return string(b)
+}
+
+// eventually waits until cond is true and fails the test after waitTimeout
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(waitTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// refusedPort returns local port nobody listens on
+func refusedPort(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot reserve port %s", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+	return port
+}
+
+// paramsOf returns params of received command as strings
+func paramsOf(cmd Command) []string {
+	strs := make([]string, len(cmd.Params))
+	for i, param := range cmd.Params {
+		strs[i] = propString(param)
+	}
+	return strs
+}
+
+// fakeSearch replaces openSearch with answers given by the test, see fakeDiscovery
+type fakeSearch struct {
+	mu      sync.Mutex
+	answers []*YeelightParams
+	err     error
+	opts    []DiscoverOptions
+	msgs    []string
+	open    int
+}
+
+// fakeDiscovery makes discovery yield answers, then report that the deadline was reached.
+// Each search gets the same answers
+func fakeDiscovery(t *testing.T, answers ...*YeelightParams) *fakeSearch {
+	s := &fakeSearch{answers: answers}
+	original := openSearch
+	openSearch = func(opts DiscoverOptions, msg string) (answerSource, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		s.opts = append(s.opts, opts)
+		s.msgs = append(s.msgs, msg)
+		if s.err != nil {
+			return nil, s.err
+		}
+		s.open++
+		return &fakeAnswers{search: s, answers: append([]*YeelightParams(nil), s.answers...)}, nil
+	}
+	t.Cleanup(func() {
+		openSearch = original
+	})
+	return s
+}
+
+// fail makes opening of the next searches fail with err
+func (s *fakeSearch) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.err = err
+}
+
+// openSearches returns number of searches which were not closed
+func (s *fakeSearch) openSearches() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.open
+}
+
+// fakeAnswers is answer source of a single fake search
+type fakeAnswers struct {
+	search  *fakeSearch
+	answers []*YeelightParams
+}
+
+func (a *fakeAnswers) next(deadline time.Time) (*YeelightParams, error) {
+	if len(a.answers) == 0 {
+		return nil, nil
+	}
+	p := a.answers[0]
+	a.answers = a.answers[1:]
+	copied := *p
+	return &copied, nil
+}
+
+func (a *fakeAnswers) close() {
+	a.search.mu.Lock()
+	defer a.search.mu.Unlock()
+
+	a.search.open--
+}
+
+// answer is discovery answer of bulb with id listening on port of local host
+func answer(id string, port int) *YeelightParams {
+	return &YeelightParams{ID: id, Location: fmt.Sprintf("yeelight://127.0.0.1:%d", port), Support: []string{"get_prop", "set_power"}}
+}
@@ -1,25 +1,55 @@
 package yeelight
 
 import (
+	"context"
+	"fmt"
 	t "github.com/akominch/yeelight/transitions"
+	"image/color"
 	"strings"
+	"time"
 )
 
-type Action int8
+// colorLoopSteps is number of hues a color loop goes through
+const colorLoopSteps = 12
+
+// minFlowStep is the shortest flow step in ms accepted by the bulb
+const minFlowStep = 50
+
+// FlowAction is what the bulb does when flow ends, the action param of start_cf
+type FlowAction int8
+
+// Action is the former name of FlowAction
+type Action = FlowAction
 
 const (
-	Recover Action = 0
-	Stay = 1
-	Off = 2
+	// Recover returns the bulb to the state before the flow
+	Recover FlowAction = 0
+	// Stay keeps the state of the last flow step
+	Stay FlowAction = 1
+	// Off turns the bulb off
+	Off FlowAction = 2
 )
 
+var flowActionNames = map[FlowAction]string{
+	Recover: "Recover",
+	Stay:    "Stay",
+	Off:     "Off",
+}
+
+func (a FlowAction) String() string {
+	if name, ok := flowActionNames[a]; ok {
+		return name
+	}
+	return fmt.Sprintf("FlowAction(%d)", int(a))
+}
+
 type Flow struct {
 	count int
-	action Action
+	action FlowAction
 	transitions []t.Transition
 }
 
-func NewFlow(count int, action Action, transitions []t.Transition) *Flow {
+func NewFlow(count int, action FlowAction, transitions []t.Transition) *Flow {
 	return &Flow{
 		count: count,
 		action: action,
@@ -27,6 +57,24 @@ func NewFlow(count int, action Action, transitions []t.Transition) *Flow {
 	}
 }
 
+// RGBStep appends color transition (mode 1)
+func (flow *Flow) RGBStep(color color.RGBA, duration int, brightness int) *Flow {
+	flow.transitions = append(flow.transitions, t.NewRGBTransition(color, duration, brightness))
+	return flow
+}
+
+// CTStep appends color temperature transition (mode 2)
+func (flow *Flow) CTStep(degrees int, duration int, brightness int) *Flow {
+	flow.transitions = append(flow.transitions, t.NewTemperatureTransition(degrees, duration, brightness))
+	return flow
+}
+
+// Sleep appends sleep transition (mode 7)
+func (flow *Flow) Sleep(duration int) *Flow {
+	flow.transitions = append(flow.transitions, t.NewSleepTransition(duration))
+	return flow
+}
+
 func (flow *Flow) AsStartParams() []interface{} {
 	count := flow.count * len(flow.transitions)
 
@@ -40,4 +88,122 @@ func (flow *Flow) AsStartParams() []interface{} {
 	expr := strings.Join(strTransitions, ",")
 
 	return []interface{}{count, flow.action, expr}
-}
\ No newline at end of file
+}
+
+// colorLoopFlow builds endless flow rotating hue over the full circle once per period
+func colorLoopFlow(period time.Duration) *Flow {
+	duration := int(period/time.Millisecond) / colorLoopSteps
+	transitions := make([]t.Transition, colorLoopSteps)
+	for i := range transitions {
+		transitions[i] = t.NewHSVTransition(i*360/colorLoopSteps, 100, duration, 100)
+	}
+	return NewFlow(0, Recover, transitions)
+}
+
+// minColorLoopPeriod is the shortest period keeping every color loop step at least 50ms long
+const minColorLoopPeriod = colorLoopSteps * minFlowStep * time.Millisecond
+
+// SetColorLoop rotates hue continuously, going through all colors once per period, at least 600ms.
+// It blocks until ctx is cancelled and stops the flow then
+func (y *Bulb) SetColorLoop(ctx context.Context, period time.Duration) error {
+	if period < minColorLoopPeriod {
+		return fmt.Errorf("the period value to set must be at least %s, got %s", minColorLoopPeriod, period)
+	}
+	if _, err := y.StartFlow(colorLoopFlow(period)); err != nil {
+		return err
+	}
+	<-ctx.Done()
+	_, err := y.StopFlow()
+	return err
+}
+
+// PresetCandleCT builds endless color temperature flow flickering like a candle,
+// for bulbs in white mode. Duration is length of the longest step, at least 50ms
+func PresetCandleCT(duration int) (*Flow, error) {
+	if duration < minFlowStep {
+		return nil, fmt.Errorf("the duration value to set must be at least %dms, got %d", minFlowStep, duration)
+	}
+	steps := []struct {
+		degrees    int
+		brightness int
+		percent    int
+	}{
+		{2700, 50, 100},
+		{2200, 30, 60},
+		{2500, 45, 80},
+		{1900, 25, 50},
+		{2400, 40, 90},
+		{2100, 35, 70},
+	}
+
+	transitions := make([]t.Transition, len(steps))
+	for i, step := range steps {
+		transitions[i] = t.NewTemperatureTransition(step.degrees, duration*step.percent/100, step.brightness)
+	}
+	return NewFlow(0, Recover, transitions), nil
+}
+
+// FlowControlResult reports whether color flow is running after start_cf or stop_cf
+type FlowControlResult struct {
+	Running bool
+}
+
+// StartFlowConfirmed starts the flow. With verify, flowing prop is read afterwards,
+// otherwise Running is derived from the ok result
+func (y *Bulb) StartFlowConfirmed(flow *Flow, verify bool) (*FlowControlResult, error) {
+	if _, err := y.StartFlow(flow); err != nil {
+		return nil, err
+	}
+	return y.flowControlResult(true, verify)
+}
+
+// StopFlowConfirmed stops the flow, verify works as in StartFlowConfirmed
+func (y *Bulb) StopFlowConfirmed(verify bool) (*FlowControlResult, error) {
+	if _, err := y.StopFlow(); err != nil {
+		return nil, err
+	}
+	return y.flowControlResult(false, verify)
+}
+
+// flowControlResult returns expected flow state, or state read from the bulb with verify
+func (y *Bulb) flowControlResult(running bool, verify bool) (*FlowControlResult, error) {
+	if !verify {
+		return &FlowControlResult{Running: running}, nil
+	}
+	res, err := y.GetProps([]string{"flowing"})
+	if err != nil {
+		return nil, err
+	}
+	return &FlowControlResult{Running: res.Result["flowing"] == "1"}, nil
+}
+
+// pulseFlow builds flow flashing color times, the bulb returns to the prior state afterwards
+func pulseFlow(c color.RGBA, times int, onMs, offMs int) *Flow {
+	return NewFlow(times, Recover, nil).
+		RGBStep(c, 50, 100).
+		Sleep(onMs).
+		RGBStep(c, 50, 1).
+		Sleep(offMs)
+}
+
+// Pulse flashes color times to signal something, each flash lit for onMs and dimmed for offMs.
+// It blocks until the pulses are done, when ctx is cancelled earlier the flow is stopped
+func (y *Bulb) Pulse(ctx context.Context, c color.RGBA, times int, onMs, offMs int) error {
+	if times < 1 {
+		return fmt.Errorf("the times value to set must be positive, got %d", times)
+	}
+	if _, err := y.StartFlow(pulseFlow(c, times, onMs, offMs)); err != nil {
+		return err
+	}
+
+	//transitions last at least 50ms
+	timer := time.NewTimer(time.Duration(times*(onMs+offMs+100)) * time.Millisecond)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		_, err := y.StopFlow()
+		return err
+	}
+}
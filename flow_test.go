@@ -0,0 +1,358 @@
+package yeelight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	c "github.com/akominch/yeelight/color"
+	"image/color"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFlowStepsSerializeTheirMode(t *testing.T) {
+	tests := []struct {
+		name string
+		flow *Flow
+		want string
+	}{
+		{"color", NewFlow(1, Stay, nil).RGBStep(color.RGBA{R: 255, A: 255}, 500, 80), "500,1,16711680,80"},
+		{"temperature", NewFlow(1, Stay, nil).CTStep(2700, 400, 30), "400,2,2700,30"},
+		{"sleep", NewFlow(1, Stay, nil).Sleep(1000), "1000,7,0,0"},
+	}
+	for _, tt := range tests {
+		want := []interface{}{1, Stay, tt.want}
+		if got := tt.flow.AsStartParams(); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s step serialized to %v, want %v", tt.name, got, want)
+		}
+	}
+}
+
+func TestFlowCountCoversAllSteps(t *testing.T) {
+	flow := NewFlow(3, Recover, nil).CTStep(6500, 500, 100).Sleep(500)
+
+	params := flow.AsStartParams()
+	if params[0] != 6 {
+		t.Errorf("expected count of 3 repetitions of 2 steps, got %v", params[0])
+	}
+	if params[2] != "500,2,6500,100,500,7,0,0" {
+		t.Errorf("unexpected flow expression %v", params[2])
+	}
+}
+
+func TestColorLoopFlowCoversFullHueRangeOncePerPeriod(t *testing.T) {
+	params := colorLoopFlow(1200 * time.Millisecond).AsStartParams()
+	if params[0] != 0 || params[1] != Recover {
+		t.Errorf("expected endless flow recovering state, got %v %v", params[0], params[1])
+	}
+
+	steps := strings.Split(params[2].(string), ",")
+	if len(steps) != colorLoopSteps*4 {
+		t.Fatalf("expected %d steps, got %v", colorLoopSteps, params[2])
+	}
+	for i := 0; i < colorLoopSteps; i++ {
+		hue := i * 360 / colorLoopSteps
+		want := fmt.Sprintf("100,1,%d,100", c.RGBToYeelight(c.HSVToRGB(hue, 100)))
+		if got := strings.Join(steps[i*4:i*4+4], ","); got != want {
+			t.Errorf("step %d is %s, want hue %d as %s", i, got, hue, want)
+		}
+	}
+}
+
+func TestSetColorLoopStopsFlowWhenCancelled(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan error, 1)
+	go func() {
+		done <- y.SetColorLoop(ctx, time.Second)
+	}()
+	f.waitCommands(2)
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("unexpected error %s", err)
+		}
+	case <-time.After(waitTimeout):
+		t.Fatal("SetColorLoop did not return after cancel")
+	}
+	// get_prop is power check of the flow start
+	if methods := f.methods(); !reflect.DeepEqual(methods, []string{"get_prop", "start_cf", "stop_cf"}) {
+		t.Errorf("expected start_cf then stop_cf, got %v", methods)
+	}
+}
+
+func TestSetColorLoopRejectsShortPeriod(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb()
+
+	if err := y.SetColorLoop(context.Background(), minColorLoopPeriod-time.Millisecond); err == nil {
+		t.Fatal("expected error for period below 600ms")
+	}
+	if methods := f.methods(); len(methods) != 0 {
+		t.Errorf("expected nothing sent, got %v", methods)
+	}
+}
+
+func TestUpdateFlowStopsThenStartsFlow(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb()
+
+	if err := y.UpdateFlow(NewFlow(0, Recover, nil).CTStep(2700, 500, 50)); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if methods := f.methods(); !reflect.DeepEqual(methods, []string{"stop_cf", "start_cf"}) {
+		t.Fatalf("expected stop_cf then start_cf, got %v", methods)
+	}
+	if got := paramsOf(f.last("start_cf")); !reflect.DeepEqual(got, []string{"0", "0", "500,2,2700,50"}) {
+		t.Errorf("unexpected start_cf params %v", got)
+	}
+}
+
+func TestUpdateFlowDoesNotStartWhenStopFails(t *testing.T) {
+	f := newFakeBulb(t)
+	f.reply(func(cmd Command) []string {
+		if cmd.Method == "stop_cf" {
+			return []string{errorLine(cmd.ID, -1, "general error")}
+		}
+		return nil
+	})
+	y := f.newBulb()
+
+	if err := y.UpdateFlow(NewFlow(0, Recover, nil).Sleep(500)); err == nil {
+		t.Fatal("expected error")
+	}
+	if methods := f.methods(); !reflect.DeepEqual(methods, []string{"stop_cf"}) {
+		t.Errorf("expected only stop_cf, got %v", methods)
+	}
+}
+
+func TestPresetCandleCTUsesWarmTemperatureSteps(t *testing.T) {
+	flow, err := PresetCandleCT(400)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	steps := strings.Split(flow.AsStartParams()[2].(string), ",")
+	if len(steps) == 0 || len(steps)%4 != 0 {
+		t.Fatalf("malformed flow expression %v", steps)
+	}
+	for i := 0; i < len(steps); i += 4 {
+		duration, _ := strconv.Atoi(steps[i])
+		degrees, _ := strconv.Atoi(steps[i+2])
+		if steps[i+1] != "2" {
+			t.Errorf("step %d has mode %s, want color temperature", i/4, steps[i+1])
+		}
+		if degrees < 1700 || degrees > 2700 {
+			t.Errorf("step %d has %dK outside of 1700-2700K", i/4, degrees)
+		}
+		if duration < minFlowStep || duration > 400 {
+			t.Errorf("step %d lasts %dms", i/4, duration)
+		}
+	}
+}
+
+func TestPresetCandleCTRejectsShortDuration(t *testing.T) {
+	if _, err := PresetCandleCT(minFlowStep - 1); err == nil {
+		t.Error("expected error for duration below 50ms")
+	}
+	if _, err := PresetCandleCT(minFlowStep); err != nil {
+		t.Errorf("unexpected error for 50ms %s", err)
+	}
+}
+
+func TestStartFlowConfirmedReflectsDeviceState(t *testing.T) {
+	tests := []struct {
+		verify  bool
+		flowing string
+		want    bool
+	}{
+		{false, "0", true},
+		{true, "1", true},
+		{true, "0", false},
+	}
+	for _, tt := range tests {
+		f := newFakeBulb(t)
+		f.setProps(map[string]string{"power": "on", "flowing": tt.flowing})
+		y := f.newBulb()
+
+		res, err := y.StartFlowConfirmed(NewFlow(0, Recover, nil).Sleep(500), tt.verify)
+		if err != nil {
+			t.Fatalf("unexpected error %s", err)
+		}
+		if res.Running != tt.want {
+			t.Errorf("verify %t with flowing %s reported running %t", tt.verify, tt.flowing, res.Running)
+		}
+		if last := f.received()[len(f.received())-1]; tt.verify && (last.Method != "get_prop" || paramsOf(last)[0] != "flowing") {
+			t.Errorf("expected flowing read after start_cf, got %s %v", last.Method, paramsOf(last))
+		}
+	}
+}
+
+func TestStopFlowConfirmed(t *testing.T) {
+	f := newFakeBulb(t)
+	f.setProps(map[string]string{"flowing": "1"})
+	y := f.newBulb()
+
+	res, err := y.StopFlowConfirmed(false)
+	if err != nil || res.Running {
+		t.Errorf("expected stopped flow without verify, got %+v %v", res, err)
+	}
+	res, err = y.StopFlowConfirmed(true)
+	if err != nil || !res.Running {
+		t.Errorf("expected flow reported running by the bulb, got %+v %v", res, err)
+	}
+}
+
+func TestStopFlowIfRunning(t *testing.T) {
+	for _, tt := range []struct {
+		flowing string
+		want    bool
+		methods []string
+	}{
+		{"1", true, []string{"get_prop", "stop_cf"}},
+		{"0", false, []string{"get_prop"}},
+	} {
+		f := newFakeBulb(t)
+		f.setProps(map[string]string{"flowing": tt.flowing})
+		y := f.newBulb()
+
+		stopped, err := y.StopFlowIfRunning()
+		if err != nil {
+			t.Fatalf("unexpected error %s", err)
+		}
+		if stopped != tt.want {
+			t.Errorf("flowing %s reported stopped %t", tt.flowing, stopped)
+		}
+		if methods := f.methods(); !reflect.DeepEqual(methods, tt.methods) {
+			t.Errorf("flowing %s expected %v, got %v", tt.flowing, tt.methods, methods)
+		}
+	}
+}
+
+func TestStopFlowIfRunningReportsStopFailure(t *testing.T) {
+	f := newFakeBulb(t)
+	f.setProps(map[string]string{"flowing": "1"})
+	f.reply(func(cmd Command) []string {
+		if cmd.Method == "stop_cf" {
+			return []string{errorLine(cmd.ID, -1, "client quota exceeded")}
+		}
+		return nil
+	})
+	y := f.newBulb()
+
+	if stopped, err := y.StopFlowIfRunning(); err == nil || stopped {
+		t.Errorf("expected stop failure, got %t %v", stopped, err)
+	}
+}
+
+func TestPulseFlowFlashesColor(t *testing.T) {
+	params := pulseFlow(color.RGBA{R: 255, A: 255}, 2, 300, 200).AsStartParams()
+
+	if params[0] != 8 || params[1] != Recover {
+		t.Errorf("expected 2 pulses of 4 steps recovering the state, got %v %v", params[0], params[1])
+	}
+	if params[2] != "50,1,16711680,100,300,7,0,0,50,1,16711680,1,200,7,0,0" {
+		t.Errorf("unexpected flow expression %v", params[2])
+	}
+}
+
+func TestPulseBlocksUntilPulsesAreDone(t *testing.T) {
+	f := newFakeBulb(t)
+	f.setProps(map[string]string{"power": "on"})
+	y := f.newBulb()
+
+	start := time.Now()
+	if err := y.Pulse(context.Background(), color.RGBA{G: 255, A: 255}, 1, 50, 50); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("expected Pulse to wait for the flow, returned after %s", elapsed)
+	}
+	if methods := f.methods(); !reflect.DeepEqual(methods, []string{"get_prop", "start_cf"}) {
+		t.Errorf("expected only start_cf, got %v", methods)
+	}
+}
+
+func TestPulseStopsFlowWhenCancelled(t *testing.T) {
+	f := newFakeBulb(t)
+	f.setProps(map[string]string{"power": "on"})
+	y := f.newBulb()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan error, 1)
+	go func() {
+		done <- y.Pulse(ctx, color.RGBA{G: 255, A: 255}, 10, 1000, 1000)
+	}()
+	f.waitCommands(2)
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("unexpected error %s", err)
+		}
+	case <-time.After(waitTimeout):
+		t.Fatal("Pulse did not return after cancel")
+	}
+	if last := f.received()[len(f.received())-1]; last.Method != "stop_cf" {
+		t.Errorf("expected stop_cf after cancel, got %s", last.Method)
+	}
+}
+
+func TestPulseRejectsInvalidTimes(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb()
+
+	if err := y.Pulse(context.Background(), color.RGBA{G: 255, A: 255}, 0, 50, 50); err == nil {
+		t.Fatal("expected error for zero times")
+	}
+	if methods := f.methods(); len(methods) != 0 {
+		t.Errorf("expected nothing sent, got %v", methods)
+	}
+}
+
+func TestFlowActionString(t *testing.T) {
+	for action, want := range map[FlowAction]string{Recover: "Recover", Stay: "Stay", Off: "Off", FlowAction(5): "FlowAction(5)"} {
+		if got := action.String(); got != want {
+			t.Errorf("FlowAction(%d).String() = %s, want %s", int(action), got, want)
+		}
+	}
+}
+
+func TestFlowActionSerializesAsNumber(t *testing.T) {
+	for action, want := range map[FlowAction]float64{Recover: 0, Stay: 1, Off: 2} {
+		f := newFakeBulb(t)
+		f.setProps(map[string]string{"power": "on"})
+		y := f.newBulb()
+
+		if _, err := y.StartFlowRaw(4, action, "500,2,2700,50,300,7,0,0"); err != nil {
+			t.Fatalf("unexpected error %s", err)
+		}
+		params := f.last("start_cf").Params
+		if got, ok := params[1].(float64); !ok || got != want {
+			t.Errorf("%s sent as %#v, want %v", action, params[1], want)
+		}
+		if got := NewFlow(1, action, nil).AsStartParams()[1]; got != action {
+			t.Errorf("flow of %s has action %v", action, got)
+		}
+	}
+}
+
+func TestStartFlowRawRequiresSupport(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.discoveredBulb(YeelightParams{ID: "0x1", Model: "mono", Support: []string{"get_prop"}})
+
+	if _, err := y.StartFlowRaw(0, Recover, "1000,7,0,0"); !errors.Is(err, ErrUnsupportedMethod) {
+		t.Errorf("expected ErrUnsupportedMethod, got %v", err)
+	}
+	if methods := f.methods(); len(methods) != 0 {
+		t.Errorf("expected nothing sent, got %v", methods)
+	}
+}
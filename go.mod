@@ -2,4 +2,7 @@ module github.com/akominch/yeelight
 
 go 1.14
 
-require github.com/lucasb-eyer/go-colorful v1.0.3
+require (
+	github.com/lucasb-eyer/go-colorful v1.0.3
+	golang.org/x/net v0.0.0-20200707034311-ab3426394381
+)
@@ -0,0 +1,149 @@
+package yeelight
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// groupConcurrency limits number of bulbs queried at once
+const groupConcurrency = 4
+
+// Group controls several bulbs together, e.g. all bulbs of a room
+type Group struct {
+	bulbs []*Bulb
+}
+
+// GroupError aggregates errors of group members. Errors is index-aligned with group bulbs,
+// nil entries belong to bulbs which succeeded
+type GroupError struct {
+	Errors []error
+	bulbs  []*Bulb
+}
+
+func NewGroup(bulbs ...*Bulb) *Group {
+	return &Group{bulbs: bulbs}
+}
+
+// Bulbs returns group members
+func (g *Group) Bulbs() []*Bulb {
+	return g.bulbs
+}
+
+// StartFlowSync starts the flow on all bulbs as close to simultaneously as possible.
+// Connections to every bulb are opened first and start_cf is fired only when all are ready.
+// Bulbs are controlled over Wi-Fi, so perfect synchronization is not guaranteed.
+// A bulb listed several times gets the flow once
+func (g *Group) StartFlowSync(flow *Flow) error {
+	errs := make([]error, len(g.bulbs))
+	// first index of every bulb, duplicates share its error
+	first := make(map[*Bulb]int)
+	for i, y := range g.bulbs {
+		if _, ok := first[y]; ok {
+			continue
+		}
+		first[y] = i
+		if errs[i] = y.checkSupport("start_cf"); errs[i] == nil {
+			errs[i] = y.EnsureOn()
+		}
+	}
+
+	params := flow.AsStartParams()
+	primed := make(chan error, len(g.bulbs))
+	fire := make(chan struct{})
+	done := make(chan struct{}, len(g.bulbs))
+
+	started := 0
+	for i, y := range g.bulbs {
+		if first[y] != i || errs[i] != nil {
+			continue
+		}
+		started++
+		go func(i int, y *Bulb) {
+			_, errs[i] = y.executeSync(y.newCommand("start_cf", params), primed, fire)
+			done <- struct{}{}
+		}(i, y)
+	}
+
+	for i := 0; i < started; i++ {
+		<-primed
+	}
+	close(fire)
+	for i := 0; i < started; i++ {
+		<-done
+	}
+	for i, y := range g.bulbs {
+		errs[i] = errs[first[y]]
+	}
+
+	return g.newError(errs)
+}
+
+// States reads state of every member in parallel. Result is index-aligned with group bulbs,
+// states of bulbs which failed are nil and their errors are reported in GroupError
+func (g *Group) States(ctx context.Context) ([]*State, error) {
+	states := make([]*State, len(g.bulbs))
+	errs := make([]error, len(g.bulbs))
+
+	sem := make(chan struct{}, groupConcurrency)
+	var wg sync.WaitGroup
+	for i, y := range g.bulbs {
+		wg.Add(1)
+		go func(i int, y *Bulb) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				errs[i] = ctx.Err()
+				return
+			}
+			defer func() { <-sem }()
+
+			if errs[i] = ctx.Err(); errs[i] == nil {
+				states[i], errs[i] = y.GetState()
+			}
+		}(i, y)
+	}
+	wg.Wait()
+
+	return states, g.newError(errs)
+}
+
+// SetNames names every member using pattern with its 1-based position, e.g. "Living Room %d"
+func (g *Group) SetNames(pattern string) error {
+	errs := make([]error, len(g.bulbs))
+	for i, y := range g.bulbs {
+		_, errs[i] = y.SetName(fmt.Sprintf(pattern, i+1))
+	}
+	return g.newError(errs)
+}
+
+// Capabilities returns capabilities of group members, index-aligned with group bulbs
+func (g *Group) Capabilities() []Capabilities {
+	caps := make([]Capabilities, len(g.bulbs))
+	for i, y := range g.bulbs {
+		caps[i] = y.Capabilities()
+	}
+	return caps
+}
+
+// newError returns GroupError if any of errs is set
+func (g *Group) newError(errs []error) error {
+	for _, err := range errs {
+		if err != nil {
+			return &GroupError{Errors: errs, bulbs: g.bulbs}
+		}
+	}
+	return nil
+}
+
+func (e *GroupError) Error() string {
+	var msgs []string
+	for i, err := range e.Errors {
+		if err != nil {
+			msgs = append(msgs, fmt.Sprintf("%s: %s", e.bulbs[i].host(), err))
+		}
+	}
+	return fmt.Sprintf("%d of %d bulbs failed. %s", len(msgs), len(e.Errors), strings.Join(msgs, "; "))
+}
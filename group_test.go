@@ -0,0 +1,240 @@
+package yeelight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flowTimes records when connections were accepted and start_cf received by fake bulbs
+type flowTimes struct {
+	mu       sync.Mutex
+	accepted []time.Time
+	started  []time.Time
+}
+
+func (ft *flowTimes) watch(f *fakeBulb) {
+	f.acceptWith(func(net.Conn) bool {
+		ft.mu.Lock()
+		defer ft.mu.Unlock()
+		ft.accepted = append(ft.accepted, time.Now())
+		return true
+	})
+	f.reply(func(cmd Command) []string {
+		if cmd.Method == "start_cf" {
+			ft.mu.Lock()
+			defer ft.mu.Unlock()
+			ft.started = append(ft.started, time.Now())
+		}
+		return nil
+	})
+}
+
+func TestStartFlowSyncPrimesConnectionsBeforeFiring(t *testing.T) {
+	var ft flowTimes
+	var bulbs []*Bulb
+	var fakes []*fakeBulb
+	for i := 0; i < 3; i++ {
+		f := newFakeBulb(t)
+		ft.watch(f)
+		fakes = append(fakes, f)
+		bulbs = append(bulbs, f.newBulb())
+	}
+
+	if err := NewGroup(bulbs...).StartFlowSync(NewFlow(1, Recover, nil).Sleep(100)); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	for i, f := range fakes {
+		if f.last("start_cf").Method != "start_cf" {
+			t.Errorf("bulb %d did not receive start_cf", i)
+		}
+	}
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	if len(ft.started) != len(fakes) {
+		t.Fatalf("expected %d start_cf, got %d", len(fakes), len(ft.started))
+	}
+	firstStart := ft.started[0]
+	for _, s := range ft.started {
+		if s.Before(firstStart) {
+			firstStart = s
+		}
+	}
+	for _, a := range ft.accepted {
+		if a.After(firstStart) {
+			t.Fatal("connection was opened after the first start_cf was fired")
+		}
+	}
+}
+
+func TestStartFlowSyncStartsDuplicateBulbOnce(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb(WithKeepAlive(true))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- NewGroup(y, y).StartFlowSync(NewFlow(1, Recover, nil).Sleep(100))
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("unexpected error %s", err)
+		}
+	case <-time.After(waitTimeout):
+		t.Fatal("StartFlowSync deadlocked on duplicate bulb")
+	}
+
+	count := 0
+	for _, method := range f.methods() {
+		if method == "start_cf" {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Errorf("expected single start_cf, got %d", count)
+	}
+}
+
+func TestStartFlowSyncReportsUnsupportedBulb(t *testing.T) {
+	supported := newFakeBulb(t)
+	unsupported := newFakeBulb(t)
+	group := NewGroup(
+		supported.newBulb(),
+		unsupported.discoveredBulb(YeelightParams{ID: "0x1", Support: []string{"get_prop", "set_power"}}),
+	)
+
+	err := group.StartFlowSync(NewFlow(1, Recover, nil).Sleep(100))
+	var groupErr *GroupError
+	if !errors.As(err, &groupErr) {
+		t.Fatalf("expected GroupError, got %v", err)
+	}
+	if groupErr.Errors[0] != nil {
+		t.Errorf("expected supported bulb to succeed, got %s", groupErr.Errors[0])
+	}
+	if !errors.Is(groupErr.Errors[1], ErrUnsupportedMethod) {
+		t.Errorf("expected ErrUnsupportedMethod, got %v", groupErr.Errors[1])
+	}
+	if len(unsupported.received()) != 0 {
+		t.Errorf("expected nothing sent to unsupported bulb, got %v", unsupported.methods())
+	}
+}
+
+func TestGroupStates(t *testing.T) {
+	var bulbs []*Bulb
+	for _, bright := range []string{"10", "20", "30"} {
+		f := newFakeBulb(t)
+		f.setProps(map[string]string{"bright": bright})
+		bulbs = append(bulbs, f.newBulb())
+	}
+	bulbs = append(bulbs, NewWithOptions("127.0.0.1", WithPort(refusedPort(t)), WithTimeout(time.Second), WithLogger(quietLogger)))
+
+	states, err := NewGroup(bulbs...).States(context.Background())
+	var groupErr *GroupError
+	if !errors.As(err, &groupErr) {
+		t.Fatalf("expected GroupError, got %v", err)
+	}
+	for i, want := range []int{10, 20, 30} {
+		if states[i] == nil || states[i].Bright != want {
+			t.Errorf("bulb %d: expected brightness %d, got %+v", i, want, states[i])
+		}
+		if groupErr.Errors[i] != nil {
+			t.Errorf("bulb %d: unexpected error %s", i, groupErr.Errors[i])
+		}
+	}
+	if states[3] != nil || groupErr.Errors[3] == nil {
+		t.Errorf("expected unreachable bulb to fail, got %+v %v", states[3], groupErr.Errors[3])
+	}
+}
+
+func TestGroupStatesBoundsConcurrency(t *testing.T) {
+	var mu sync.Mutex
+	running, peak := 0, 0
+	slow := func(cmd Command) []string {
+		mu.Lock()
+		running++
+		if running > peak {
+			peak = running
+		}
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return nil
+	}
+
+	var bulbs []*Bulb
+	for i := 0; i < groupConcurrency+3; i++ {
+		f := newFakeBulb(t)
+		f.reply(slow)
+		bulbs = append(bulbs, f.newBulb())
+	}
+	if _, err := NewGroup(bulbs...).States(context.Background()); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if peak > groupConcurrency {
+		t.Errorf("expected at most %d bulbs queried at once, got %d", groupConcurrency, peak)
+	}
+}
+
+func TestGroupSetNames(t *testing.T) {
+	var fakes []*fakeBulb
+	var bulbs []*Bulb
+	for i := 0; i < 3; i++ {
+		f := newFakeBulb(t)
+		fakes = append(fakes, f)
+		bulbs = append(bulbs, f.newBulb())
+	}
+	fakes[1].reply(func(cmd Command) []string {
+		return []string{errorLine(cmd.ID, -1, "general error")}
+	})
+
+	err := NewGroup(bulbs...).SetNames("Living Room %d")
+	var groupErr *GroupError
+	if !errors.As(err, &groupErr) {
+		t.Fatalf("expected GroupError, got %v", err)
+	}
+	if groupErr.Errors[0] != nil || groupErr.Errors[1] == nil || groupErr.Errors[2] != nil {
+		t.Errorf("expected only the second bulb to fail, got %v", groupErr.Errors)
+	}
+	for i, f := range fakes {
+		if got, want := paramsOf(f.last("set_name"))[0], fmt.Sprintf("Living Room %d", i+1); got != want {
+			t.Errorf("bulb %d named %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestGroupCapabilities(t *testing.T) {
+	f := newFakeBulb(t)
+	group := NewGroup(
+		f.discoveredBulb(YeelightParams{ID: "0x1", Model: "ceiling4", Support: []string{"get_prop", "start_cf", "bg_set_power", "cron_add"}}),
+		f.discoveredBulb(YeelightParams{ID: "0x2", Model: "color", Support: []string{"get_prop", "set_rgb", "set_ct_abx", "set_music"}}),
+		f.newBulb(),
+	)
+
+	caps := group.Capabilities()
+	if len(caps) != 3 {
+		t.Fatalf("expected capabilities of 3 bulbs, got %d", len(caps))
+	}
+	ceiling, color, byIP := caps[0], caps[1], caps[2]
+	if ceiling.ID != "0x1" || ceiling.Model.Type != ModelCeiling || !ceiling.Known {
+		t.Errorf("unexpected ceiling capabilities %+v", ceiling)
+	}
+	if !ceiling.Flows || !ceiling.Background || !ceiling.DelayOff || ceiling.Color || ceiling.MusicMode {
+		t.Errorf("unexpected ceiling features %+v", ceiling)
+	}
+	if !color.Color || !color.ColorTemperature || !color.MusicMode || color.Flows || color.Background {
+		t.Errorf("unexpected color bulb features %+v", color)
+	}
+	if byIP.Known || !byIP.Background || !byIP.MusicMode {
+		t.Errorf("expected bulb without support list to report all features, got %+v", byIP)
+	}
+}
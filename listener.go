@@ -0,0 +1,328 @@
+package yeelight
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+)
+
+// MethodDisconnected is method of the last notification sent by listener
+// when connection to the bulb drops, e.g. after power cut or Wi-Fi loss
+const MethodDisconnected = "disconnected"
+
+// errListenerStopped is returned by Relisten of stopped listener
+var errListenerStopped = errors.New("listener is already stopped")
+
+// Listener receives NOTIFICATION events of the bulb
+type Listener struct {
+	// Notifications delivers notifications, closed when the listener stops.
+	// When connection drops, notification with MethodDisconnected is sent before closing
+	Notifications <-chan *Notification
+	// Errors delivers error which stopped the listener, nothing is sent on Close
+	Errors <-chan error
+
+	notifCh chan *Notification
+	errCh   chan error
+	allowed map[string]bool
+	done    chan struct{}
+	once    sync.Once
+
+	mu sync.Mutex
+	// session is nil when Relisten failed and closed Notifications
+	session *listenSession
+}
+
+// listenSession is connection of the listener to a single bulb
+type listenSession struct {
+	// replace is closed by Relisten, the session then keeps Notifications open
+	replace chan struct{}
+	exited  chan struct{}
+	// closedOutput is set before exited is closed
+	closedOutput bool
+}
+
+// IsDisconnected reports whether n is the sentinel sent when the bulb went offline
+func (n *Notification) IsDisconnected() bool {
+	return n.Method == MethodDisconnected
+}
+
+// Close stops the listener and closes its connection
+func (l *Listener) Close() {
+	l.once.Do(func() {
+		close(l.done)
+	})
+}
+
+// Relisten stops listening to the current bulb and starts listening to y,
+// notifications of y are delivered on the same Notifications channel.
+// When connecting to y fails, the listener is stopped
+func (l *Listener) Relisten(ctx context.Context, y *Bulb) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	s := l.session
+	if nil == s {
+		return errListenerStopped
+	}
+	close(s.replace)
+	<-s.exited
+	if s.closedOutput {
+		l.session = nil
+		return errListenerStopped
+	}
+
+	if err := y.startSession(ctx, l); err != nil {
+		l.session = nil
+		close(l.notifCh)
+		return err
+	}
+	return nil
+}
+
+// Listen connects to device and listens for NOTIFICATION events
+func (y *Bulb) Listen() (<-chan *Notification, chan<- struct{}, error) {
+	l, err := y.NewListener()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return l.Notifications, l.done, nil
+}
+
+// NewListener connects to device and listens for NOTIFICATION events,
+// errors which stop listening are delivered on Listener.Errors
+func (y *Bulb) NewListener() (*Listener, error) {
+	return y.ListenContext(context.Background())
+}
+
+// ListenContext works as NewListener, the listener is also stopped
+// and its connection closed when ctx is cancelled
+func (y *Bulb) ListenContext(ctx context.Context) (*Listener, error) {
+	return y.listen(ctx, nil)
+}
+
+// ListenFiltered works as ListenContext, but forwards only notifications with given methods
+func (y *Bulb) ListenFiltered(ctx context.Context, methods ...string) (*Listener, error) {
+	allowed := make(map[string]bool)
+	for _, method := range methods {
+		allowed[method] = true
+	}
+	return y.listen(ctx, allowed)
+}
+
+// listen starts listener forwarding notifications with allowed methods, all when allowed is nil
+func (y *Bulb) listen(ctx context.Context, allowed map[string]bool) (*Listener, error) {
+	notifCh := make(chan *Notification, y.notificationBuffer)
+	errCh := make(chan error, 1)
+	l := &Listener{
+		Notifications: notifCh,
+		Errors:        errCh,
+		notifCh:       notifCh,
+		errCh:         errCh,
+		allowed:       allowed,
+		done:          make(chan struct{}, 1),
+	}
+
+	if err := y.startSession(ctx, l); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// startSession connects to the bulb and forwards its notifications to the listener
+func (y *Bulb) startSession(ctx context.Context, l *Listener) error {
+	if err := y.acquireConn(ctx); err != nil {
+		return err
+	}
+	conn, err := net.DialTimeout("tcp", y.address(), y.timeout)
+	if err != nil {
+		y.releaseConn()
+		return fmt.Errorf("cannot connect to %s. %s", y.address(), err)
+	}
+
+	s := &listenSession{replace: make(chan struct{}), exited: make(chan struct{})}
+	l.session = s
+	stop := make(chan struct{})
+
+	y.addLiveListener(1)
+	go func(c net.Conn) {
+		//registered first so it runs last, Relisten waits for exited before starting the next session
+		//and needs the connection and its slot released
+		defer func() {
+			select {
+			case <-s.replace:
+			default:
+				close(l.notifCh)
+				s.closedOutput = true
+			}
+			close(s.exited)
+		}()
+		defer y.releaseConn()
+		//cached state is not updated anymore
+		defer y.addLiveListener(-1)
+		//make sure connection is closed when method returns
+		defer closeConnection(conn)
+
+		//unblock reading when listener is stopped
+		exited := make(chan struct{})
+		defer close(exited)
+		go func() {
+			select {
+			case <-l.done:
+			case <-ctx.Done():
+			case <-s.replace:
+			case <-exited:
+				return
+			}
+			close(stop)
+			closeConnection(c)
+		}()
+
+		connReader := bufio.NewReader(c)
+		//partial object which was not finished by the previous line
+		var partial string
+		for {
+			data, err := connReader.ReadString('\n')
+			if err != nil {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				err = fmt.Errorf("connection to %s closed. %s", y.address(), err)
+				//deliver only the first error, previous one may still be unread after Relisten
+				select {
+				case l.errCh <- err:
+				default:
+				}
+				//tell consumers the bulb went offline, the sentinel bypasses the filter and overflow policy
+				select {
+				case l.notifCh <- &Notification{Method: MethodDisconnected, Params: map[string]string{"error": err.Error()}}:
+				case <-stop:
+				}
+				return
+			}
+
+			//skip blank keep-alive lines
+			if strings.TrimSpace(data) == "" {
+				continue
+			}
+			var objects []string
+			objects, partial = splitObjects(partial + data)
+			for _, object := range objects {
+				rs, err := parseNotification(object, y.strict)
+				if err != nil {
+					y.logger.Printf("malformed notification skipped. %s", err)
+					continue
+				}
+				if rs.Method == "props" {
+					y.updateLive(rs.Params)
+				}
+				if l.allowed != nil && !l.allowed[rs.Method] {
+					continue
+				}
+				y.deliver(l.notifCh, rs, stop)
+			}
+		}
+
+	}(conn)
+
+	return nil
+}
+
+// maxPartial limits size of unfinished object kept between lines
+const maxPartial = 16 * 1024
+
+// splitObjects splits data into JSON objects, bulbs may send several of them without newline between.
+// Unfinished trailing object is returned as partial to be prepended to the next line,
+// malformed data is returned as object, so it is reported when parsed
+func splitObjects(data string) ([]string, string) {
+	var objects []string
+	dec := json.NewDecoder(strings.NewReader(data))
+	for {
+		var raw json.RawMessage
+		offset := dec.InputOffset()
+		err := dec.Decode(&raw)
+		if err == io.EOF {
+			return objects, ""
+		}
+		if err == io.ErrUnexpectedEOF && len(data)-int(offset) < maxPartial {
+			return objects, data[offset:]
+		}
+		if err != nil {
+			return append(objects, data[offset:]), ""
+		}
+		objects = append(objects, string(raw))
+	}
+}
+
+// parseNotification decodes notification line. Params sent as numbers are converted to strings,
+// in strict mode numeric props which are not numbers are rejected
+func parseNotification(data string, strict bool) (*Notification, error) {
+	var raw struct {
+		Method string                 `json:"method"`
+		Params map[string]interface{} `json:"params"`
+	}
+	if err := json.Unmarshal([]byte(data), &raw); err != nil {
+		return nil, err
+	}
+
+	n := &Notification{Method: raw.Method, Params: make(map[string]string, len(raw.Params))}
+	for key, val := range raw.Params {
+		n.Params[key] = propString(val)
+	}
+	if strict {
+		if err := checkProps(n.Params); err != nil {
+			return nil, err
+		}
+	}
+	return n, nil
+}
+
+// deliver sends notification to channel according to overflow policy
+func (y *Bulb) deliver(notifCh chan *Notification, n *Notification, done <-chan struct{}) {
+	switch y.overflowPolicy {
+	case Block:
+		select {
+		case notifCh <- n:
+		case <-done:
+		}
+	case DropOldest:
+		for {
+			select {
+			case notifCh <- n:
+				return
+			default:
+			}
+			select {
+			case old := <-notifCh:
+				y.dropped(old)
+			default:
+				//unbuffered channel has nothing to drop
+				y.dropped(n)
+				return
+			}
+		}
+	default:
+		select {
+		case notifCh <- n:
+		default:
+			y.dropped(n)
+		}
+	}
+}
+
+// dropped reports notification dropped because channel is full
+func (y *Bulb) dropped(n *Notification) {
+	if y.onDropped != nil {
+		y.onDropped(n)
+		return
+	}
+	y.logger.Println("Channel is full, notification dropped")
+}
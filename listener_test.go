@@ -0,0 +1,473 @@
+package yeelight
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestListenerReportsConnectionClosedByBulb(t *testing.T) {
+	f := newFakeBulb(t)
+	f.acceptWith(func(net.Conn) bool { return false })
+	y := f.newBulb()
+
+	l, err := y.NewListener()
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	defer l.Close()
+
+	select {
+	case err := <-l.Errors:
+		if err == nil {
+			t.Fatal("expected error")
+		}
+	case <-time.After(waitTimeout):
+		t.Fatal("no error delivered after bulb closed connection")
+	}
+}
+
+func TestListenReportsDialError(t *testing.T) {
+	y := NewWithOptions("127.0.0.1", WithPort(refusedPort(t)), WithTimeout(time.Second), WithLogger(quietLogger))
+
+	if _, err := y.NewListener(); err == nil {
+		t.Fatal("expected dial error")
+	}
+}
+
+func TestDeliverDropNewestCallsHook(t *testing.T) {
+	var dropped []*Notification
+	y := NewWithOptions("127.0.0.1", WithLogger(quietLogger), WithOnDropped(func(n *Notification) {
+		dropped = append(dropped, n)
+	}))
+	ch := make(chan *Notification, 1)
+	first, second := &Notification{Method: "props"}, &Notification{Method: "props"}
+
+	y.deliver(ch, first, nil)
+	y.deliver(ch, second, nil)
+
+	if got := <-ch; got != first {
+		t.Error("expected the first notification to be kept")
+	}
+	if len(dropped) != 1 || dropped[0] != second {
+		t.Errorf("expected the second notification to be dropped, got %v", dropped)
+	}
+}
+
+func TestDeliverDropOldest(t *testing.T) {
+	var dropped []*Notification
+	y := NewWithOptions("127.0.0.1", WithLogger(quietLogger), WithOverflowPolicy(DropOldest), WithOnDropped(func(n *Notification) {
+		dropped = append(dropped, n)
+	}))
+	ch := make(chan *Notification, 1)
+	first, second := &Notification{Method: "props"}, &Notification{Method: "props"}
+
+	y.deliver(ch, first, nil)
+	y.deliver(ch, second, nil)
+
+	if got := <-ch; got != second {
+		t.Error("expected the newest notification to be kept")
+	}
+	if len(dropped) != 1 || dropped[0] != first {
+		t.Errorf("expected the oldest notification to be dropped, got %v", dropped)
+	}
+}
+
+func TestDeliverBlockWaitsForConsumer(t *testing.T) {
+	y := NewWithOptions("127.0.0.1", WithLogger(quietLogger), WithOverflowPolicy(Block), WithOnDropped(func(n *Notification) {
+		t.Error("nothing should be dropped")
+	}))
+	ch := make(chan *Notification, 1)
+	first, second := &Notification{Method: "props"}, &Notification{Method: "props"}
+	y.deliver(ch, first, nil)
+
+	delivered := make(chan struct{})
+	go func() {
+		y.deliver(ch, second, nil)
+		close(delivered)
+	}()
+	select {
+	case <-delivered:
+		t.Fatal("deliver did not block on full channel")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	if got := <-ch; got != first {
+		t.Error("expected the first notification first")
+	}
+	<-delivered
+	if got := <-ch; got != second {
+		t.Error("expected the second notification after the consumer read")
+	}
+}
+
+func TestDeliverBlockStopsWhenDone(t *testing.T) {
+	y := NewWithOptions("127.0.0.1", WithLogger(quietLogger), WithOverflowPolicy(Block))
+	ch := make(chan *Notification)
+	done := make(chan struct{})
+	close(done)
+
+	// returns instead of blocking forever
+	y.deliver(ch, &Notification{Method: "props"}, done)
+}
+
+// waitClosed waits until notifications channel is closed, skipping delivered notifications
+func waitClosed(t *testing.T, ch <-chan *Notification) {
+	t.Helper()
+	timer := time.NewTimer(waitTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				return
+			}
+		case <-timer.C:
+			t.Fatal("notifications channel was not closed")
+		}
+	}
+}
+
+func TestListenContextTearsDownOnCancel(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	l, err := y.ListenContext(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	f.waitConns(1)
+	cancel()
+
+	waitClosed(t, l.Notifications)
+	f.waitConns(0)
+	select {
+	case err := <-l.Errors:
+		t.Errorf("expected no error on cancel, got %s", err)
+	default:
+	}
+}
+
+func TestListenStopsOnDone(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb()
+
+	notifications, done, err := y.Listen()
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	f.waitConns(1)
+	done <- struct{}{}
+
+	waitClosed(t, notifications)
+	f.waitConns(0)
+}
+
+// nextNotification returns the next notification delivered by listener
+func nextNotification(t *testing.T, l *Listener) *Notification {
+	t.Helper()
+	select {
+	case n, ok := <-l.Notifications:
+		if !ok {
+			t.Fatal("notifications channel was closed")
+		}
+		return n
+	case <-time.After(waitTimeout):
+		t.Fatal("no notification delivered")
+	}
+	return nil
+}
+
+func TestListenerSkipsMalformedAndBlankLines(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb()
+	l, err := y.NewListener()
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	defer l.Close()
+	f.waitConns(1)
+
+	f.push(`{not json}`)
+	f.push(`{"method":"props","params":["power","on"]}`)
+	f.push("")
+	f.push(propsLine(map[string]string{"power": "on"}))
+
+	n := nextNotification(t, l)
+	if n.Method != "props" || n.Params["power"] != "on" {
+		t.Errorf("expected only the valid notification, got %+v", n)
+	}
+}
+
+func TestParseNotificationRejectsMalformedData(t *testing.T) {
+	if _, err := parseNotification(`{"method":"props",`, false); err == nil {
+		t.Error("expected error for malformed data")
+	}
+	n, err := parseNotification(`{"method":"props","params":{"bright":50}}`, false)
+	if err != nil || n.Params["bright"] != "50" {
+		t.Errorf("expected numeric param converted to string, got %+v %v", n, err)
+	}
+}
+
+func TestListenFilteredForwardsMatchingMethods(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb()
+	l, err := y.ListenFiltered(context.Background(), "props")
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	defer l.Close()
+	f.waitConns(1)
+
+	f.push(`{"method":"scene","params":{"name":"party"}}`)
+	f.push(`{"method":"props","params":{"bright":"10"}}`)
+	f.push(`{"method":"scene","params":{"name":"night"}}`)
+	f.push(`{"method":"props","params":{"bright":"20"}}`)
+
+	for _, want := range []string{"10", "20"} {
+		n := nextNotification(t, l)
+		if n.Method != "props" || n.Params["bright"] != want {
+			t.Errorf("expected props with bright %s, got %+v", want, n)
+		}
+	}
+}
+
+func TestStrictParseNotificationRejectsMalformedValue(t *testing.T) {
+	data := `{"method":"props","params":{"bright":"high","power":"on"}}`
+
+	n, err := parseNotification(data, false)
+	if err != nil || n.Params["bright"] != "high" {
+		t.Errorf("expected lenient parse to keep the value, got %+v %v", n, err)
+	}
+	if _, err := parseNotification(data, true); err == nil {
+		t.Error("expected strict parse to reject non numeric bright")
+	}
+}
+
+func TestRelistenSwitchesBetweenBulbs(t *testing.T) {
+	first, second := newFakeBulb(t), newFakeBulb(t)
+	l, err := first.newBulb().NewListener()
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	defer l.Close()
+	first.waitConns(1)
+
+	first.push(propsLine(map[string]string{"bright": "10"}))
+	if n := nextNotification(t, l); n.Params["bright"] != "10" {
+		t.Fatalf("expected notification of the first bulb, got %+v", n)
+	}
+
+	if err := l.Relisten(context.Background(), second.newBulb()); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	first.waitConns(0)
+	second.waitConns(1)
+
+	second.push(propsLine(map[string]string{"bright": "20"}))
+	if n := nextNotification(t, l); n.Params["bright"] != "20" {
+		t.Errorf("expected notification of the second bulb, got %+v", n)
+	}
+	select {
+	case err := <-l.Errors:
+		t.Errorf("unexpected error after relisten %v", err)
+	default:
+	}
+}
+
+func TestRelistenWithSingleConnectionSlot(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb(WithMaxConnections(1), WithTimeout(100*time.Millisecond))
+	l, err := y.NewListener()
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	defer l.Close()
+
+	// the slot of the old session has to be free when the new one starts
+	for i := 0; i < 20; i++ {
+		if err := l.Relisten(context.Background(), y); err != nil {
+			t.Fatalf("relisten %d failed, %s", i, err)
+		}
+		if held := len(y.conns); held != 1 {
+			t.Fatalf("expected only the new session holding a slot, got %d", held)
+		}
+	}
+	eventually(t, "only the last session connected", func() bool {
+		return f.acceptedConns() == 21 && f.openConns() == 1
+	})
+	f.push(propsLine(map[string]string{"bright": "30"}))
+	if n := nextNotification(t, l); n.Params["bright"] != "30" {
+		t.Errorf("expected notification after relisten, got %+v", n)
+	}
+}
+
+func TestRelistenFailureStopsListener(t *testing.T) {
+	f := newFakeBulb(t)
+	l, err := f.newBulb().NewListener()
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	defer l.Close()
+	unreachable := NewWithOptions("127.0.0.1", WithPort(refusedPort(t)), WithTimeout(time.Second), WithLogger(quietLogger))
+
+	if err := l.Relisten(context.Background(), unreachable); err == nil {
+		t.Fatal("expected dial error")
+	}
+	waitClosed(t, l.Notifications)
+	f.waitConns(0)
+
+	if err := l.Relisten(context.Background(), f.newBulb()); err == nil {
+		t.Fatal("expected error from relisten of stopped listener")
+	}
+	if got := f.acceptedConns(); got != 1 {
+		t.Errorf("stopped listener connected again, %d connections", got)
+	}
+}
+
+func TestRelistenOfClosedListener(t *testing.T) {
+	f := newFakeBulb(t)
+	l, err := f.newBulb().NewListener()
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	l.Close()
+	waitClosed(t, l.Notifications)
+
+	if err := l.Relisten(context.Background(), f.newBulb()); err != errListenerStopped {
+		t.Fatalf("expected errListenerStopped, got %v", err)
+	}
+}
+
+func TestListenerSendsDisconnectedSentinelWhenBulbDrops(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb()
+	l, err := y.NewListener()
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	defer l.Close()
+	f.waitConns(1)
+
+	f.dropConns()
+
+	n := nextNotification(t, l)
+	if !n.IsDisconnected() || n.Params["error"] == "" {
+		t.Fatalf("expected disconnected sentinel with error, got %+v", n)
+	}
+	waitClosed(t, l.Notifications)
+}
+
+func TestListenerCloseSendsNoSentinel(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb()
+	l, err := y.NewListener()
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	f.waitConns(1)
+
+	l.Close()
+	for n := range l.Notifications {
+		if n.IsDisconnected() {
+			t.Fatal("sentinel sent although the listener was closed")
+		}
+	}
+}
+
+func TestNotificationBufferKeepsNotificationsOfSlowConsumer(t *testing.T) {
+	f := newFakeBulb(t)
+	var mu sync.Mutex
+	dropped := 0
+	y := f.newBulb(WithOnDropped(func(*Notification) {
+		mu.Lock()
+		defer mu.Unlock()
+		dropped++
+	}))
+	l, err := y.NewListener()
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	defer l.Close()
+	f.waitConns(1)
+
+	for i := 0; i < defaultNotificationBuffer; i++ {
+		f.push(propsLine(map[string]string{"bright": strconv.Itoa(i + 1)}))
+	}
+	// the consumer is busy until all notifications are buffered
+	eventually(t, "notifications buffered", func() bool {
+		return len(l.Notifications) == defaultNotificationBuffer
+	})
+
+	for i := 0; i < defaultNotificationBuffer; i++ {
+		if n := nextNotification(t, l); n.Params["bright"] != strconv.Itoa(i+1) {
+			t.Fatalf("expected bright %d, got %+v", i+1, n)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if dropped != 0 {
+		t.Errorf("expected no dropped notifications, got %d", dropped)
+	}
+}
+
+func TestWithNotificationBufferSetsSize(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb(WithNotificationBuffer(4))
+	l, err := y.NewListener()
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	defer l.Close()
+
+	if got := cap(l.Notifications); got != 4 {
+		t.Errorf("expected buffer of 4, got %d", got)
+	}
+	if got := NewWithOptions("127.0.0.1", WithNotificationBuffer(-1)).notificationBuffer; got != defaultNotificationBuffer {
+		t.Errorf("expected negative size ignored, got %d", got)
+	}
+}
+
+func TestSplitObjects(t *testing.T) {
+	objects, partial := splitObjects(`{"id":1}{"id":2}` + crlf)
+	if len(objects) != 2 || objects[0] != `{"id":1}` || objects[1] != `{"id":2}` || partial != "" {
+		t.Errorf("expected two objects, got %q partial %q", objects, partial)
+	}
+
+	objects, partial = splitObjects(`{"id":1}{"method":"pro`)
+	if len(objects) != 1 || partial != `{"method":"pro` {
+		t.Errorf("expected unfinished object kept as partial, got %q partial %q", objects, partial)
+	}
+
+	objects, partial = splitObjects(`{"id":1}}garbage` + crlf)
+	if len(objects) != 2 || objects[1] != "}garbage"+crlf || partial != "" {
+		t.Errorf("expected malformed rest returned as object, got %q partial %q", objects, partial)
+	}
+}
+
+func TestListenerSplitsNotificationsSentTogether(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb()
+	l, err := y.NewListener()
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	defer l.Close()
+	f.waitConns(1)
+
+	f.push(propsLine(map[string]string{"power": "on"}) + propsLine(map[string]string{"bright": "40"}))
+	// an object may also be continued by the next line
+	f.push(`{"method":"props",`)
+	f.push(`"params":{"ct":"4000"}}`)
+
+	for _, want := range []string{"power", "bright", "ct"} {
+		n := nextNotification(t, l)
+		if _, ok := n.Params[want]; !ok {
+			t.Errorf("expected notification with %s, got %+v", want, n)
+		}
+	}
+}
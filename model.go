@@ -0,0 +1,138 @@
+package yeelight
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ModelType is kind of device derived from model reported in discovery answer
+type ModelType int
+
+const (
+	UnknownModel ModelType = iota
+	ModelMono
+	ModelColor
+	ModelStripe
+	ModelCeiling
+	ModelBSLamp
+	ModelDeskLamp
+	ModelCTBulb
+)
+
+// Model is device model, Raw keeps the reported string also for unknown models
+type Model struct {
+	Type ModelType
+	Raw  string
+}
+
+// modelPrefixes maps prefixes of reported model strings to model types
+var modelPrefixes = []struct {
+	prefix    string
+	modelType ModelType
+}{
+	{"mono", ModelMono},
+	{"color", ModelColor},
+	{"stripe", ModelStripe},
+	{"strip", ModelStripe},
+	{"ceiling", ModelCeiling},
+	{"ceila", ModelCeiling},
+	{"bslamp", ModelBSLamp},
+	{"desklamp", ModelDeskLamp},
+	{"lamp", ModelDeskLamp},
+	{"ct", ModelCTBulb},
+}
+
+// ParseModel converts model string from discovery answer to Model
+func ParseModel(raw string) Model {
+	for _, m := range modelPrefixes {
+		if strings.HasPrefix(raw, m.prefix) {
+			return Model{Type: m.modelType, Raw: raw}
+		}
+	}
+	return Model{Type: UnknownModel, Raw: raw}
+}
+
+func (m Model) String() string {
+	return m.Raw
+}
+
+// Model returns model from discovery, UnknownModel for bulbs created by ip
+func (y *Bulb) Model() Model {
+	p := y.discovery()
+	if p == nil {
+		return Model{Type: UnknownModel}
+	}
+	return ParseModel(p.Model)
+}
+
+// Capabilities summarizes features the bulb supports according to its discovery answer
+type Capabilities struct {
+	ID    string
+	Model Model
+	// Known is false for bulbs without support list, all features are then reported as supported
+	Known            bool
+	Color            bool
+	ColorTemperature bool
+	Flows            bool
+	Scenes           bool
+	MusicMode        bool
+	Background       bool
+	DelayOff         bool
+}
+
+// Capabilities returns features supported by the bulb, see Supports
+func (y *Bulb) Capabilities() Capabilities {
+	p := y.discovery()
+	return Capabilities{
+		ID:               y.ID(),
+		Model:            y.Model(),
+		Known:            p != nil && len(p.Support) > 0,
+		Color:            y.Supports("set_rgb"),
+		ColorTemperature: y.Supports("set_ct_abx"),
+		Flows:            y.Supports("start_cf"),
+		Scenes:           y.Supports("set_scene"),
+		MusicMode:        y.Supports("set_music"),
+		Background:       y.Supports("bg_set_power"),
+		DelayOff:         y.Supports("cron_add"),
+	}
+}
+
+// hasColor reports whether main light of the model can show colors, unknown models are assumed to
+func (t ModelType) hasColor() bool {
+	switch t {
+	case ModelMono, ModelCTBulb, ModelDeskLamp, ModelCeiling:
+		return false
+	}
+	return true
+}
+
+// SupportedColorModes returns modes the bulb can be switched to, derived from model and support list
+func (y *Bulb) SupportedColorModes() []Mode {
+	modelType := y.Model().Type
+	modes := []Mode{Normal}
+	if modelType.hasColor() && y.Supports("set_rgb") {
+		modes = append(modes, RGB)
+	}
+	if modelType.hasColor() && y.Supports("set_hsv") {
+		modes = append(modes, HSV)
+	}
+	if y.Supports("start_cf") {
+		modes = append(modes, ColorFlow)
+	}
+	if modelType == ModelCeiling {
+		modes = append(modes, Moonlight)
+	}
+	return modes
+}
+
+// checkColorMode returns ErrUnsupportedMethod when the bulb cannot be switched to one of modes
+func (y *Bulb) checkColorMode(modes ...Mode) error {
+	for _, supported := range y.SupportedColorModes() {
+		for _, mode := range modes {
+			if supported == mode {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%s mode is not supported by the bulb. %w", modes[0], ErrUnsupportedMethod)
+}
@@ -0,0 +1,157 @@
+package yeelight
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseModel(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want ModelType
+	}{
+		{"mono", ModelMono},
+		{"mono1", ModelMono},
+		{"color", ModelColor},
+		{"color4", ModelColor},
+		{"stripe", ModelStripe},
+		{"strip6", ModelStripe},
+		{"ceiling", ModelCeiling},
+		{"ceila", ModelCeiling},
+		{"bslamp1", ModelBSLamp},
+		{"desklamp", ModelDeskLamp},
+		{"lamp1", ModelDeskLamp},
+		{"ct_bulb", ModelCTBulb},
+		{"fancy9", UnknownModel},
+		{"", UnknownModel},
+	}
+	for _, tt := range tests {
+		m := ParseModel(tt.raw)
+		if m.Type != tt.want || m.Raw != tt.raw {
+			t.Errorf("ParseModel(%q) = %+v, want type %d", tt.raw, m, tt.want)
+		}
+	}
+}
+
+func TestBulbModel(t *testing.T) {
+	if m := NewWithOptions("192.168.1.10").Model(); m.Type != UnknownModel {
+		t.Errorf("expected unknown model for bulb created by ip, got %+v", m)
+	}
+	found := newDiscoveredBulb(&YeelightParams{ID: "0x1", Location: "yeelight://192.168.1.10:55443", Model: "ceiling3"})
+	if m := found.Model(); m.Type != ModelCeiling || m.String() != "ceiling3" {
+		t.Errorf("expected ceiling3, got %+v", m)
+	}
+}
+
+func TestSupports(t *testing.T) {
+	supported := newDiscoveredBulb(&YeelightParams{ID: "0x1", Location: "yeelight://127.0.0.1:55443",
+		Support: []string{"get_prop", "start_cf"}})
+	if !supported.Supports("start_cf") || supported.Supports("set_music") {
+		t.Error("expected support list of discovery to be used")
+	}
+	if byIP := NewWithOptions("127.0.0.1"); !byIP.Supports("set_music") {
+		t.Error("expected bulb without support list to allow all methods")
+	}
+}
+
+func TestUnsupportedMethodsFailBeforeSending(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.discoveredBulb(YeelightParams{ID: "0x1", Support: []string{"get_prop", "set_power"}})
+
+	if _, err := y.StartFlow(NewFlow(1, Recover, nil).Sleep(500)); !errors.Is(err, ErrUnsupportedMethod) {
+		t.Errorf("StartFlow expected ErrUnsupportedMethod, got %v", err)
+	}
+	if _, err := y.SetScene("ct", 2700, 50); !errors.Is(err, ErrUnsupportedMethod) {
+		t.Errorf("SetScene expected ErrUnsupportedMethod, got %v", err)
+	}
+	if err := y.StartMusicMode("127.0.0.1", 0); !errors.Is(err, ErrUnsupportedMethod) {
+		t.Errorf("StartMusicMode expected ErrUnsupportedMethod, got %v", err)
+	}
+	if methods := f.methods(); len(methods) != 0 {
+		t.Errorf("expected nothing sent, got %v", methods)
+	}
+}
+
+func TestSupportedMethodsAreSent(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.discoveredBulb(YeelightParams{ID: "0x1", Support: []string{"get_prop", "set_power", "start_cf", "set_scene"}})
+
+	if _, err := y.SetScene("ct", 2700, 50); err != nil {
+		t.Errorf("unexpected error %s", err)
+	}
+	if _, err := y.StartFlow(NewFlow(1, Recover, nil).Sleep(500)); err != nil {
+		t.Errorf("unexpected error %s", err)
+	}
+	f.last("set_scene")
+	f.last("start_cf")
+}
+
+func TestSetAmbientLight(t *testing.T) {
+	for _, on := range []bool{true, false} {
+		f := newFakeBulb(t)
+		y := f.discoveredBulb(YeelightParams{ID: "0x1", Model: "ceiling4", Support: []string{"get_prop", "bg_set_power"}})
+
+		if _, err := y.SetAmbientLight(on); err != nil {
+			t.Fatalf("unexpected error %s", err)
+		}
+		want := "off"
+		if on {
+			want = "on"
+		}
+		if got := paramsOf(f.last("bg_set_power"))[0]; got != want {
+			t.Errorf("SetAmbientLight(%t) sent %s", on, got)
+		}
+	}
+}
+
+func TestSetAmbientLightUnsupported(t *testing.T) {
+	f := newFakeBulb(t)
+	bulbs := []*Bulb{
+		f.discoveredBulb(YeelightParams{ID: "0x1", Model: "color", Support: []string{"get_prop", "bg_set_power"}}),
+		f.discoveredBulb(YeelightParams{ID: "0x2", Model: "mono", Support: []string{"get_prop"}}),
+		f.discoveredBulb(YeelightParams{ID: "0x3", Model: "ceiling4", Support: []string{"get_prop"}}),
+	}
+	for _, y := range bulbs {
+		if _, err := y.SetAmbientLight(true); !errors.Is(err, ErrUnsupportedMethod) {
+			t.Errorf("%s expected ErrUnsupportedMethod, got %v", y.Model(), err)
+		}
+	}
+	if methods := f.methods(); len(methods) != 0 {
+		t.Errorf("expected nothing sent, got %v", methods)
+	}
+}
+
+func TestSupportedColorModes(t *testing.T) {
+	tests := []struct {
+		p    *YeelightParams
+		want []Mode
+	}{
+		{nil, []Mode{Normal, RGB, HSV, ColorFlow}},
+		{&YeelightParams{Model: "color", Support: []string{"set_rgb", "start_cf"}}, []Mode{Normal, RGB, ColorFlow}},
+		{&YeelightParams{Model: "mono", Support: []string{"set_rgb", "set_hsv"}}, []Mode{Normal}},
+		{&YeelightParams{Model: "ceiling4", Support: []string{"start_cf"}}, []Mode{Normal, ColorFlow, Moonlight}},
+	}
+	for _, tt := range tests {
+		y := NewWithOptions("127.0.0.1")
+		if tt.p != nil {
+			tt.p.Location = "yeelight://127.0.0.1:55443"
+			y = newDiscoveredBulb(tt.p)
+		}
+		if got := y.SupportedColorModes(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%+v supports %v, want %v", tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestColorModeCheckRejectsUnsupportedMode(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.discoveredBulb(YeelightParams{ID: "0x1", Model: "mono", Support: []string{"get_prop", "set_rgb", "set_hsv"}})
+
+	if _, err := y.SetRGBInt(0xff0000, 300); !errors.Is(err, ErrUnsupportedMethod) {
+		t.Errorf("expected ErrUnsupportedMethod for rgb on mono bulb, got %v", err)
+	}
+	if methods := f.methods(); len(methods) != 0 {
+		t.Errorf("expected nothing sent, got %v", methods)
+	}
+}
@@ -0,0 +1,248 @@
+package yeelight
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/akominch/yeelight/utils"
+	"math"
+	"net"
+	"time"
+)
+
+// dimStep is interval between brightness steps sent by DimTo
+const dimStep = 50 * time.Millisecond
+
+// ErrNotInMusicMode is returned by commands which need music mode to be started
+var ErrNotInMusicMode = errors.New("music mode is not started")
+
+// StartMusicMode starts music server on host:port and asks the bulb to connect to it.
+// While music mode is on, commands are sent over that connection without waiting for
+// result and are not limited by the bulb quota. Props are still read over control connection.
+// Empty host is detected as local address used to reach the bulb, zero port is picked by the system
+func (y *Bulb) StartMusicMode(host string, port int) error {
+	if y.IsMusicMode() {
+		return nil
+	}
+	if err := y.checkSupport("set_music"); err != nil {
+		return err
+	}
+
+	if host == "" {
+		var err error
+		if host, err = y.localHost(); err != nil {
+			return err
+		}
+	}
+	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", host, port))
+	if err != nil {
+		return fmt.Errorf("cannot start music server. %s", err)
+	}
+	defer listener.Close()
+	port = listener.Addr().(*net.TCPAddr).Port
+
+	if _, err := y.ExecuteCommand("set_music", 1, host, port); err != nil {
+		return err
+	}
+
+	listener.(*net.TCPListener).SetDeadline(time.Now().Add(y.timeout))
+	conn, err := listener.Accept()
+	if err != nil {
+		return fmt.Errorf("bulb did not connect to music server. %s", err)
+	}
+
+	y.mu.Lock()
+	y.musicConn = conn
+	y.musicBuf = bufio.NewWriter(conn)
+	y.music = true
+	y.setMusicState(true)
+	y.mu.Unlock()
+
+	return nil
+}
+
+// StopMusicMode closes music connection and switches the bulb back to normal mode
+func (y *Bulb) StopMusicMode() error {
+	y.mu.Lock()
+	if err := y.flushMusic(); err != nil {
+		y.logger.Println(err)
+	}
+	y.closeMusic()
+	y.music = false
+	y.mu.Unlock()
+
+	_, err := y.ExecuteCommand("set_music", 0)
+	return err
+}
+
+// IsMusicMode reports whether the bulb is in music mode
+func (y *Bulb) IsMusicMode() bool {
+	y.mu.Lock()
+	defer y.mu.Unlock()
+
+	return y.music
+}
+
+// localHost returns local ip of connection to the bulb, the bulb can reach us on it
+func (y *Bulb) localHost() (string, error) {
+	conn, err := y.dial()
+	if err != nil {
+		return "", err
+	}
+	defer y.releaseConn()
+	defer closeConnection(conn)
+
+	return conn.LocalAddr().(*net.TCPAddr).IP.String(), nil
+}
+
+// reconcileMusicMode updates music mode flag with music_on value reported by the bulb.
+// Music mode is only switched off, music connection of another client cannot be used here
+func (y *Bulb) reconcileMusicMode(musicOn bool) {
+	y.mu.Lock()
+	defer y.mu.Unlock()
+
+	if y.music == musicOn {
+		return
+	}
+	y.logger.Printf("music mode mismatch: bulb reports music_on=%t, expected %t", musicOn, y.music)
+	if !musicOn {
+		y.closeMusic()
+		y.music = false
+	}
+}
+
+// sendMusic writes command to music connection, the bulb does not answer there
+func (y *Bulb) sendMusic(cmd *Command) (*CommandResult, error) {
+	b, _ := json.Marshal(cmd)
+	y.record(recordSent, string(b))
+	res := &CommandResult{ID: cmd.ID, Result: []interface{}{"ok"}}
+	if y.musicBatch <= 1 && y.musicFlushEvery <= 0 {
+		if _, err := fmt.Fprint(y.musicConn, string(b)+crlf); err != nil {
+			return nil, fmt.Errorf("cannot send command to music connection %s", err)
+		}
+		return res, nil
+	}
+
+	y.musicBuf.WriteString(string(b) + crlf)
+	y.musicPending++
+	if y.musicBatch > 0 && y.musicPending >= y.musicBatch {
+		if err := y.flushMusic(); err != nil {
+			return nil, err
+		}
+	} else if y.musicFlushEvery > 0 && nil == y.musicTimer {
+		y.musicTimer = time.AfterFunc(y.musicFlushEvery, func() {
+			y.mu.Lock()
+			defer y.mu.Unlock()
+			if err := y.flushMusic(); err != nil {
+				y.logger.Println(err)
+			}
+		})
+	}
+	return res, nil
+}
+
+// FlushMusic writes commands buffered in music mode, see WithMusicBuffer
+func (y *Bulb) FlushMusic() error {
+	y.mu.Lock()
+	defer y.mu.Unlock()
+
+	return y.flushMusic()
+}
+
+// flushMusic writes buffered music commands, the mutex has to be held
+func (y *Bulb) flushMusic() error {
+	if nil != y.musicTimer {
+		y.musicTimer.Stop()
+		y.musicTimer = nil
+	}
+	if nil == y.musicBuf || y.musicPending == 0 {
+		return nil
+	}
+	y.musicPending = 0
+	if err := y.musicBuf.Flush(); err != nil {
+		return fmt.Errorf("cannot send commands to music connection %s", err)
+	}
+	return nil
+}
+
+// closeMusic drops music connection with unsent commands, the mutex has to be held
+func (y *Bulb) closeMusic() {
+	if nil != y.musicTimer {
+		y.musicTimer.Stop()
+		y.musicTimer = nil
+	}
+	closeConnection(y.musicConn)
+	y.musicConn = nil
+	y.musicBuf = nil
+	y.musicPending = 0
+	y.setMusicState(false)
+}
+
+// ExecuteCommandNoReply writes command to music connection and returns without waiting for result.
+// It returns ErrNotInMusicMode outside music mode, where unread replies would pile up on the socket
+func (y *Bulb) ExecuteCommandNoReply(method string, params ...interface{}) error {
+	cmd := y.newCommand(method, params)
+
+	y.mu.Lock()
+	defer y.mu.Unlock()
+
+	if nil == y.musicConn {
+		return ErrNotInMusicMode
+	}
+	_, err := y.sendMusic(cmd)
+	return err
+}
+
+// Linear easing changes brightness at constant speed
+func Linear(t float64) float64 {
+	return t
+}
+
+// EaseInOut easing starts and ends brightness change slowly
+func EaseInOut(t float64) float64 {
+	return (1 - math.Cos(math.Pi*t)) / 2
+}
+
+// DimTo changes brightness to target over given time following easing curve, which maps
+// progress 0.0-1.0 to change fraction 0.0-1.0. It sends a set_bright step every 50ms,
+// so music mode has to be started. Current brightness is read before dimming
+func (y *Bulb) DimTo(ctx context.Context, target int, over time.Duration, easing func(float64) float64) error {
+	if !y.IsMusicMode() {
+		return ErrNotInMusicMode
+	}
+	res, err := y.GetProps([]string{"bright"})
+	if err != nil {
+		return err
+	}
+	from, err := parseIntProp(res.Result["bright"])
+	if err != nil {
+		return fmt.Errorf("cannot read current brightness, invalid bright value %q", res.Result["bright"])
+	}
+	target = utils.GetBrightnessValue(target)
+
+	steps := int(over / dimStep)
+	if steps < 1 {
+		steps = 1
+	}
+	ticker := time.NewTicker(dimStep)
+	defer ticker.Stop()
+
+	for i := 1; i <= steps; i++ {
+		progress := easing(float64(i) / float64(steps))
+		value := from + int(math.Round(float64(target-from)*progress))
+		if _, err := y.ExecuteCommand("set_bright", utils.GetBrightnessValue(value), Smooth, int(dimStep/time.Millisecond)); err != nil {
+			return err
+		}
+		if i == steps {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
@@ -0,0 +1,258 @@
+package yeelight
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetStateWarnsWhenBulbReportsUnexpectedMusicMode(t *testing.T) {
+	f := newFakeBulb(t)
+	f.setProps(map[string]string{"music_on": "1"})
+	var logs bytes.Buffer
+	y := f.newBulb(WithLogger(log.New(&logs, "", 0)))
+
+	state, err := y.GetState()
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if !state.MusicOn {
+		t.Error("expected state with music on")
+	}
+	if !strings.Contains(logs.String(), "music mode mismatch") {
+		t.Errorf("expected mismatch warning, got %q", logs.String())
+	}
+	// music connection belongs to another client, commands must not be routed to it
+	if y.IsMusicMode() {
+		t.Error("expected music mode to stay off without music connection")
+	}
+}
+
+func TestGetStateSwitchesMusicModeOffWhenBulbReportsOff(t *testing.T) {
+	f := newFakeBulb(t)
+	f.setProps(map[string]string{"music_on": "0"})
+	y := f.newBulb()
+	y.music = true
+
+	if _, err := y.GetState(); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if y.IsMusicMode() {
+		t.Error("expected music mode to be switched off")
+	}
+}
+
+func TestDimToFollowsEasing(t *testing.T) {
+	tests := []struct {
+		name   string
+		easing func(float64) float64
+		want   []string
+	}{
+		{"linear", Linear, []string{"20", "30", "40", "50"}},
+		{"ease-in-out", EaseInOut, []string{"16", "30", "44", "50"}},
+	}
+	for _, tt := range tests {
+		f := newFakeBulb(t)
+		f.enableMusic()
+		f.setProps(map[string]string{"bright": "10"})
+		y := f.newBulb()
+		if err := y.StartMusicMode("127.0.0.1", 0); err != nil {
+			t.Fatalf("cannot start music mode %s", err)
+		}
+
+		if err := y.DimTo(context.Background(), 50, 4*dimStep, tt.easing); err != nil {
+			t.Fatalf("%s: unexpected error %s", tt.name, err)
+		}
+		var got []string
+		for _, cmd := range f.waitMusic(len(tt.want)) {
+			if cmd.Method != "set_bright" {
+				t.Fatalf("%s: unexpected music command %s", tt.name, cmd.Method)
+			}
+			got = append(got, paramsOf(cmd)[0])
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected steps %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestDimToNeedsMusicMode(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb()
+
+	if err := y.DimTo(context.Background(), 50, time.Second, Linear); err != ErrNotInMusicMode {
+		t.Fatalf("expected ErrNotInMusicMode, got %v", err)
+	}
+}
+
+func TestDimToReportsMalformedBrightness(t *testing.T) {
+	for _, bright := range []string{"", "dim"} {
+		f := newFakeBulb(t)
+		f.enableMusic()
+		f.setProps(map[string]string{"bright": bright})
+		y := f.newBulb()
+		if err := y.StartMusicMode("127.0.0.1", 0); err != nil {
+			t.Fatalf("cannot start music mode %s", err)
+		}
+
+		if err := y.DimTo(context.Background(), 50, 4*dimStep, Linear); err == nil {
+			t.Errorf("bright %q expected error", bright)
+		}
+		time.Sleep(50 * time.Millisecond)
+		if got := f.musicCommands(); len(got) != 0 {
+			t.Errorf("bright %q expected no dimming, got %d steps", bright, len(got))
+		}
+	}
+}
+
+func TestDimToStopsWhenCancelled(t *testing.T) {
+	f := newFakeBulb(t)
+	f.enableMusic()
+	f.setProps(map[string]string{"bright": "10"})
+	y := f.newBulb()
+	if err := y.StartMusicMode("127.0.0.1", 0); err != nil {
+		t.Fatalf("cannot start music mode %s", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := y.DimTo(ctx, 50, time.Second, Linear); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if got := len(f.waitMusic(1)); got != 1 {
+		t.Errorf("expected single step before cancellation, got %d", got)
+	}
+}
+
+func TestLocalHostIsLocalIPOfBulbConnection(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb()
+
+	host, err := y.localHost()
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if host != "127.0.0.1" {
+		t.Errorf("expected 127.0.0.1, got %s", host)
+	}
+}
+
+func TestStartMusicModeDetectsHost(t *testing.T) {
+	f := newFakeBulb(t)
+	f.enableMusic()
+	y := f.newBulb()
+
+	if err := y.StartMusicMode("", 0); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	params := paramsOf(f.last("set_music"))
+	if params[1] != "127.0.0.1" || params[2] == "0" {
+		t.Errorf("expected detected host and assigned port, got %v", params)
+	}
+	if !y.IsMusicMode() {
+		t.Error("expected music mode to be started")
+	}
+}
+
+func TestExecuteCommandNoReplyNeedsMusicMode(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb()
+
+	if err := y.ExecuteCommandNoReply("set_bright", 10); err != ErrNotInMusicMode {
+		t.Fatalf("expected ErrNotInMusicMode, got %v", err)
+	}
+	if methods := f.methods(); len(methods) != 0 {
+		t.Errorf("expected nothing sent, got %v", methods)
+	}
+}
+
+func TestExecuteCommandNoReplyDoesNotWaitForReply(t *testing.T) {
+	f := newFakeBulb(t)
+	f.enableMusic()
+	y := f.newBulb(WithTimeout(time.Second))
+	if err := y.StartMusicMode("127.0.0.1", 0); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	// the fake bulb never answers music commands, reading a reply would block for the timeout
+	start := time.Now()
+	for i := 1; i <= 3; i++ {
+		if err := y.ExecuteCommandNoReply("set_bright", i*10); err != nil {
+			t.Fatalf("unexpected error %s", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Errorf("commands waited for replies, took %s", elapsed)
+	}
+	for i, cmd := range f.waitMusic(3) {
+		if got := paramsOf(cmd)[0]; got != strconv.Itoa((i+1)*10) {
+			t.Errorf("music command %d has brightness %s", i, got)
+		}
+	}
+}
+
+// startBufferedMusic returns bulb in music mode with buffered music connection
+func startBufferedMusic(t *testing.T, batch int, flushEvery time.Duration) (*fakeBulb, *Bulb) {
+	f := newFakeBulb(t)
+	f.enableMusic()
+	y := f.newBulb(WithMusicBuffer(batch, flushEvery))
+	if err := y.StartMusicMode("127.0.0.1", 0); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	return f, y
+}
+
+func TestMusicBufferWritesFullBatch(t *testing.T) {
+	f, y := startBufferedMusic(t, 3, 0)
+
+	for i := 0; i < 2; i++ {
+		if _, err := y.ExecuteCommand("set_bright", 10); err != nil {
+			t.Fatalf("unexpected error %s", err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got := len(f.musicCommands()); got != 0 {
+		t.Fatalf("expected commands kept in buffer, %d were sent", got)
+	}
+	if _, err := y.ExecuteCommand("set_bright", 10); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	f.waitMusic(3)
+}
+
+func TestFlushMusicSendsBufferedCommands(t *testing.T) {
+	f, y := startBufferedMusic(t, 10, 0)
+
+	for i := 1; i <= 2; i++ {
+		if err := y.ExecuteCommandNoReply("set_bright", i*10); err != nil {
+			t.Fatalf("unexpected error %s", err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got := len(f.musicCommands()); got != 0 {
+		t.Fatalf("expected commands kept in buffer, %d were sent", got)
+	}
+	if err := y.FlushMusic(); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	commands := f.waitMusic(2)
+	if paramsOf(commands[0])[0] != "10" || paramsOf(commands[1])[0] != "20" {
+		t.Errorf("expected buffered commands in order, got %v", commands)
+	}
+}
+
+func TestMusicBufferFlushesPeriodically(t *testing.T) {
+	f, y := startBufferedMusic(t, 0, 20*time.Millisecond)
+
+	for i := 0; i < 2; i++ {
+		if err := y.ExecuteCommandNoReply("set_bright", 10); err != nil {
+			t.Fatalf("unexpected error %s", err)
+		}
+	}
+	f.waitMusic(2)
+}
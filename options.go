@@ -0,0 +1,176 @@
+package yeelight
+
+import (
+	"log"
+	"time"
+)
+
+// OverflowPolicy defines what Listen does when notification channel is full
+type OverflowPolicy int
+
+const (
+	// DropNewest drops the notification which does not fit into the channel
+	DropNewest OverflowPolicy = iota
+	// DropOldest drops the oldest queued notification to make room for the new one
+	DropOldest
+	// Block waits until the consumer reads from the channel
+	Block
+)
+
+// Option configures bulb created with NewWithOptions
+type Option func(*Bulb)
+
+// WithPort sets TCP port of the bulb control server (55443 by default)
+func WithPort(port int) Option {
+	return func(y *Bulb) {
+		y.port = port
+	}
+}
+
+// WithEffect sets effect used for transitions (Smooth by default)
+func WithEffect(effect EffectType) Option {
+	return func(y *Bulb) {
+		y.effect = effect
+	}
+}
+
+// WithTimeout sets timeout for connecting to the bulb and reading its answers
+func WithTimeout(timeout time.Duration) Option {
+	return func(y *Bulb) {
+		y.timeout = timeout
+	}
+}
+
+// WithLogger sets logger used for warnings
+func WithLogger(logger *log.Logger) Option {
+	return func(y *Bulb) {
+		y.logger = logger
+	}
+}
+
+// WithKeepAlive keeps single TCP connection open between commands instead of dialing for each one
+func WithKeepAlive(keepAlive bool) Option {
+	return func(y *Bulb) {
+		y.keepAlive = keepAlive
+	}
+}
+
+// WithPingInterval makes keep-alive connection send cheap get_prop when it was idle for interval,
+// so the bulb and NAT do not drop it. Pings count to the bulb command quota, keep interval long
+func WithPingInterval(interval time.Duration) Option {
+	return func(y *Bulb) {
+		y.pingInterval = interval
+	}
+}
+
+// WithRateLimit sets minimal interval between two commands sent to the bulb
+func WithRateLimit(interval time.Duration) Option {
+	return func(y *Bulb) {
+		y.rateLimit = interval
+	}
+}
+
+// WithOverflowPolicy sets what Listen does when notification channel is full (DropNewest by default)
+func WithOverflowPolicy(policy OverflowPolicy) Option {
+	return func(y *Bulb) {
+		y.overflowPolicy = policy
+	}
+}
+
+// WithOnDropped sets hook called with every notification dropped by Listen
+func WithOnDropped(hook func(*Notification)) Option {
+	return func(y *Bulb) {
+		y.onDropped = hook
+	}
+}
+
+// WithUnknownPropHook sets hook called with every prop requested by GetProps which the library
+// does not know, instead of logging a warning
+func WithUnknownPropHook(hook func(prop string)) Option {
+	return func(y *Bulb) {
+		y.onUnknownProp = hook
+	}
+}
+
+// WithAutoFallback makes commands unsupported by the bulb fall back to supported ones,
+// e.g. set_hsv is replaced by set_rgb
+func WithAutoFallback(autoFallback bool) Option {
+	return func(y *Bulb) {
+		y.autoFallback = autoFallback
+	}
+}
+
+// WithQueueDepthHook sets hook called with number of queued and running commands whenever it changes
+func WithQueueDepthHook(hook func(depth int)) Option {
+	return func(y *Bulb) {
+		y.onQueueDepth = hook
+	}
+}
+
+// WithMaxConnections limits number of TCP connections opened to the bulb at once (4 by default),
+// commands and listeners wait for a free slot up to the timeout. Values below 1 are ignored
+func WithMaxConnections(n int) Option {
+	return func(y *Bulb) {
+		if n >= 1 {
+			y.maxConnections = n
+		}
+	}
+}
+
+// WithStopFlowOnSet stops running color flow before set_* commands, which the bulb ignores while flowing
+func WithStopFlowOnSet(stopFlowOnSet bool) Option {
+	return func(y *Bulb) {
+		y.stopFlowOnSet = stopFlowOnSet
+	}
+}
+
+// WithStopFlowOnTurnOff stops running color flow before turning the bulb off,
+// so the flow is not restarted on the next power on (disabled by default)
+func WithStopFlowOnTurnOff(stopFlowOnOff bool) Option {
+	return func(y *Bulb) {
+		y.stopFlowOnOff = stopFlowOnOff
+	}
+}
+
+// WithAutoPowerOn makes set_* commands turn the bulb on first (enabled by default)
+func WithAutoPowerOn(autoPowerOn bool) Option {
+	return func(y *Bulb) {
+		y.autoPowerOn = autoPowerOn
+	}
+}
+
+// WithErrorWhenOff makes set_* commands return ErrBulbOff instead of being sent,
+// when auto power on is disabled and the bulb is recently known to be off
+func WithErrorWhenOff(errorWhenOff bool) Option {
+	return func(y *Bulb) {
+		y.errorWhenOff = errorWhenOff
+	}
+}
+
+// WithStrict makes state and notification parsing fail on values which cannot be converted
+// instead of leaving them zero
+func WithStrict(strict bool) Option {
+	return func(y *Bulb) {
+		y.strict = strict
+	}
+}
+
+// WithNotificationBuffer sets size of listener Notifications buffer (16 by default),
+// so briefly busy consumer does not make notifications be dropped
+func WithNotificationBuffer(size int) Option {
+	return func(y *Bulb) {
+		if size >= 0 {
+			y.notificationBuffer = size
+		}
+	}
+}
+
+// WithMusicBuffer buffers commands sent in music mode and writes them together every
+// batch commands or flushEvery after the first buffered one, whichever comes first.
+// Zero values disable the limit, see also FlushMusic
+func WithMusicBuffer(batch int, flushEvery time.Duration) Option {
+	return func(y *Bulb) {
+		y.musicBatch = batch
+		y.musicFlushEvery = flushEvery
+	}
+}
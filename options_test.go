@@ -0,0 +1,92 @@
+package yeelight
+
+import (
+	"bytes"
+	"log"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewWithOptionsAppliesOptions(t *testing.T) {
+	logger := log.New(&bytes.Buffer{}, "", 0)
+	y := NewWithOptions("192.168.1.10",
+		WithPort(1234),
+		WithEffect(Sudden),
+		WithTimeout(time.Second),
+		WithLogger(logger),
+		WithKeepAlive(true),
+		WithRateLimit(200*time.Millisecond),
+	)
+	defer y.Close()
+
+	if y.addr != "192.168.1.10:1234" {
+		t.Errorf("expected address 192.168.1.10:1234, got %s", y.addr)
+	}
+	if y.effect != Sudden {
+		t.Errorf("expected effect %s, got %s", Sudden, y.effect)
+	}
+	if y.timeout != time.Second {
+		t.Errorf("expected timeout 1s, got %s", y.timeout)
+	}
+	if y.logger != logger {
+		t.Error("logger was not applied")
+	}
+	if _, ok := y.client.(*persistentClient); !ok {
+		t.Errorf("expected persistent client, got %T", y.client)
+	}
+	if y.rateLimit != 200*time.Millisecond {
+		t.Errorf("expected rate limit 200ms, got %s", y.rateLimit)
+	}
+}
+
+func TestNewWithOptionsDefaults(t *testing.T) {
+	y := NewWithOptions("192.168.1.10")
+
+	if y.addr != "192.168.1.10:55443" {
+		t.Errorf("expected default port, got %s", y.addr)
+	}
+	if y.effect != Smooth {
+		t.Errorf("expected effect %s, got %s", Smooth, y.effect)
+	}
+	if y.timeout != timeout {
+		t.Errorf("expected timeout %s, got %s", timeout, y.timeout)
+	}
+	if _, ok := y.client.(*dialClient); !ok {
+		t.Errorf("expected dial client, got %T", y.client)
+	}
+}
+
+func TestNewKeepsConfigEffect(t *testing.T) {
+	y := New(BulbConfig{Ip: "192.168.1.10", Effect: Sudden})
+
+	if y.effect != Sudden {
+		t.Errorf("expected effect %s, got %s", Sudden, y.effect)
+	}
+	if y.addr != "192.168.1.10:55443" {
+		t.Errorf("expected default port, got %s", y.addr)
+	}
+}
+
+func TestRateLimitSpacesConcurrentCommands(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb(WithRateLimit(50 * time.Millisecond))
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := y.ExecuteCommand("set_bright", 10); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// the first command is sent at once, the other two wait for their slots
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("expected commands spaced by 50ms, all were sent in %s", elapsed)
+	}
+}
@@ -3,12 +3,16 @@ package yeelight
 import (
 	"encoding/json"
 	"log"
+	"net"
 	"strconv"
 	"strings"
 )
 
 type YeelightParams struct {
+	ID        string   `json:"id"`
+	Location  string   `json:"location"`
 	Model     string   `json:"model"`
+	FwVer     int      `json:"fw_ver"`
 	Support   []string `json:"support"`
 	Power     string   `json:"power"`
 	Bright    int      `json:"bright"`
@@ -26,9 +30,10 @@ func parseAnswer(msg string) *YeelightParams {
 	for _, line := range arr {
 		if strings.Contains(line, ":") {
 			//fmt.Println(line)
-			lineArr := strings.Split(line, ": ")
+			lineArr := strings.SplitN(line, ":", 2)
 			key := lineArr[0]
-			value := lineArr[1]
+			//value may be empty, e.g. for unnamed bulb
+			value := strings.TrimSpace(lineArr[1])
 
 			switch key {
 			case "support":
@@ -58,3 +63,46 @@ func parseAnswer(msg string) *YeelightParams {
 
 	return params
 }
+
+// hostPort returns bulb ip and control port from Location
+func (p *YeelightParams) hostPort() (string, int, bool) {
+	if !strings.HasPrefix(p.Location, "yeelight://") {
+		return "", 0, false
+	}
+	host, portStr, err := net.SplitHostPort(strings.TrimPrefix(p.Location, "yeelight://"))
+	if err != nil {
+		return "", 0, false
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return "", 0, false
+	}
+	return host, port, true
+}
+
+// Key identifies device among discovery answers: device id, or ip:port when id is missing
+func (p *YeelightParams) Key() string {
+	if p.ID != "" {
+		return p.ID
+	}
+	return strings.TrimPrefix(p.Location, "yeelight://")
+}
+
+// valid reports whether the answer has device id and usable location
+func (p *YeelightParams) valid() bool {
+	_, _, ok := p.hostPort()
+	return p.ID != "" && ok
+}
+
+// inSubnet reports whether bulb ip from Location is within subnet, nil subnet matches all
+func (p *YeelightParams) inSubnet(subnet *net.IPNet) bool {
+	if subnet == nil {
+		return true
+	}
+	host, _, ok := p.hostPort()
+	if !ok {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && subnet.Contains(ip)
+}
@@ -0,0 +1,72 @@
+package yeelight
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParamsKey(t *testing.T) {
+	first := &YeelightParams{ID: "0x1", Location: "yeelight://192.168.1.10:55443"}
+	moved := &YeelightParams{ID: "0x1", Location: "yeelight://192.168.1.20:55443"}
+	other := &YeelightParams{ID: "0x2", Location: "yeelight://192.168.1.10:55443"}
+
+	if first.Key() != moved.Key() {
+		t.Errorf("same id produced keys %s and %s", first.Key(), moved.Key())
+	}
+	if first.Key() == other.Key() {
+		t.Errorf("different ids produced the same key %s", first.Key())
+	}
+	noID := &YeelightParams{Location: "yeelight://192.168.1.10:55443"}
+	if got := noID.Key(); got != "192.168.1.10:55443" {
+		t.Errorf("expected ip:port key without id, got %s", got)
+	}
+}
+
+func TestDiscoverAllReturnsEachBulbOnce(t *testing.T) {
+	fakeDiscovery(t, answer("0x1", 55443), answer("0x2", 55443), answer("0x1", 55443))
+
+	bulbs, err := DiscoverAll(DiscoverOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if len(bulbs) != 2 || bulbs[0].ID() != "0x1" || bulbs[1].ID() != "0x2" {
+		t.Fatalf("expected bulbs 0x1 and 0x2 once, got %d bulbs", len(bulbs))
+	}
+}
+
+// ssdpAnswer builds discovery answer of a bulb with given extra headers
+func ssdpAnswer(headers ...string) string {
+	lines := append([]string{
+		"HTTP/1.1 200 OK",
+		"Cache-Control: max-age=3600",
+		"Location: yeelight://192.168.1.10:55443",
+		"id: 0x1",
+		"model: color",
+		"fw_ver: 18",
+		"support: get_prop set_power",
+		"power: on",
+		"bright: 100",
+	}, headers...)
+	return strings.Join(lines, crlf) + crlf
+}
+
+func TestParseAnswerName(t *testing.T) {
+	tests := []struct {
+		headers []string
+		want    string
+	}{
+		{[]string{"name: desk"}, "desk"},
+		{[]string{"name: living room"}, "living room"},
+		{[]string{"name: "}, ""},
+		{nil, ""},
+	}
+	for _, tt := range tests {
+		p := parseAnswer(ssdpAnswer(tt.headers...))
+		if p.Name != tt.want {
+			t.Errorf("headers %v parsed name %q, want %q", tt.headers, p.Name, tt.want)
+		}
+		if p.ID != "0x1" || p.FwVer != 18 || len(p.Support) != 2 {
+			t.Errorf("unexpected params %+v", p)
+		}
+	}
+}
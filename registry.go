@@ -0,0 +1,88 @@
+package yeelight
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// RegistryEntry is bulb known to Registry
+type RegistryEntry struct {
+	Bulb *Bulb
+	// Params is the latest discovery answer of the bulb
+	Params    *YeelightParams
+	FirstSeen time.Time
+	LastSeen  time.Time
+}
+
+// Registry accumulates bulbs found by repeated discoveries, keyed by device id.
+// A bulb found again keeps its Bulb, so its options and connections are preserved
+type Registry struct {
+	mu      sync.Mutex
+	entries map[string]*RegistryEntry
+	opts    []Option
+}
+
+// NewRegistry creates empty registry, options are applied to every new bulb
+func NewRegistry(opts ...Option) *Registry {
+	return &Registry{entries: make(map[string]*RegistryEntry), opts: opts}
+}
+
+// Discover runs DiscoverAll and adds found bulbs to the registry, it returns bulbs found by this run
+func (r *Registry) Discover(opts DiscoverOptions) ([]*Bulb, error) {
+	bulbs, err := DiscoverAll(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make([]*Bulb, 0, len(bulbs))
+	for _, y := range bulbs {
+		found = append(found, r.add(y.discovery()))
+	}
+	return found, nil
+}
+
+// add creates entry for new bulb or updates the existing one, it returns bulb of the entry.
+// Existing bulb gets the new discovery answer, so its metadata and address follow the device
+func (r *Registry) add(p *YeelightParams) *Bulb {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if e, ok := r.entries[p.Key()]; ok {
+		e.Bulb.setDiscovery(p)
+		e.Params = p
+		e.LastSeen = now
+		return e.Bulb
+	}
+	e := &RegistryEntry{Bulb: newDiscoveredBulb(p, r.opts...), Params: p, FirstSeen: now, LastSeen: now}
+	r.entries[p.Key()] = e
+	return e.Bulb
+}
+
+// Get returns entry of bulb with given device id
+func (r *Registry) Get(id string) (RegistryEntry, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e, ok := r.entries[id]
+	if !ok {
+		return RegistryEntry{}, false
+	}
+	return *e, true
+}
+
+// All returns entries of all known bulbs sorted by device id
+func (r *Registry) All() []RegistryEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	all := make([]RegistryEntry, 0, len(r.entries))
+	for _, e := range r.entries {
+		all = append(all, *e)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Bulb.ID() < all[j].Bulb.ID()
+	})
+	return all
+}
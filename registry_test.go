@@ -0,0 +1,57 @@
+package yeelight
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRegistryUpdatesRediscoveredBulbs(t *testing.T) {
+	before, after := newFakeBulb(t), newFakeBulb(t)
+	s := fakeDiscovery(t, answer("0x1", before.port()), answer("0x2", before.port()))
+	r := NewRegistry(WithLogger(quietLogger), WithTimeout(time.Second))
+
+	if found, err := r.Discover(DiscoverOptions{}); err != nil || len(found) != 2 {
+		t.Fatalf("expected 2 bulbs, got %d %v", len(found), err)
+	}
+	first, _ := r.Get("0x1")
+
+	// the bulb got new address and name
+	moved := answer("0x1", after.port())
+	moved.Name = "desk"
+	s.mu.Lock()
+	s.answers = []*YeelightParams{moved}
+	s.mu.Unlock()
+	time.Sleep(time.Millisecond)
+
+	found, err := r.Discover(DiscoverOptions{})
+	if err != nil || len(found) != 1 {
+		t.Fatalf("expected 1 bulb, got %d %v", len(found), err)
+	}
+	if all := r.All(); len(all) != 2 || all[0].Bulb.ID() != "0x1" || all[1].Bulb.ID() != "0x2" {
+		t.Fatalf("expected entries 0x1 and 0x2 once, got %d entries", len(all))
+	}
+
+	second, ok := r.Get("0x1")
+	if !ok || second.Bulb != first.Bulb || found[0] != first.Bulb {
+		t.Fatal("rediscovered bulb was replaced instead of updated")
+	}
+	if !second.FirstSeen.Equal(first.FirstSeen) || !second.LastSeen.After(first.LastSeen) {
+		t.Errorf("unexpected timestamps first %s last %s", second.FirstSeen, second.LastSeen)
+	}
+	if second.Params.Name != "desk" || second.Bulb.discovery().Name != "desk" {
+		t.Errorf("expected new discovery answer kept, got %+v", second.Params)
+	}
+
+	if _, err := second.Bulb.TurnOn(); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if len(before.received()) != 0 || len(after.received()) != 1 {
+		t.Errorf("expected command sent to the new address, old got %d new got %d", len(before.received()), len(after.received()))
+	}
+}
+
+func TestRegistryGetUnknownBulb(t *testing.T) {
+	if _, ok := NewRegistry().Get("0x9"); ok {
+		t.Error("expected unknown bulb not found")
+	}
+}
@@ -0,0 +1,120 @@
+package yeelight
+
+import (
+	"fmt"
+	c "github.com/akominch/yeelight/color"
+	"image/color"
+	"math"
+	"time"
+)
+
+// SetScene sets the bulb directly to given state, turning it on if it is off.
+// Class is one of "color", "hsv", "ct", "cf" or "auto_delay_off"
+func (y *Bulb) SetScene(class string, values ...interface{}) (*CommandResult, error) {
+	if err := y.checkSupport("set_scene"); err != nil {
+		return nil, err
+	}
+	return y.ExecuteCommand("set_scene", append([]interface{}{class}, values...))
+}
+
+// BgSetScene works as SetScene for background light of dual-light models
+func (y *Bulb) BgSetScene(class string, values ...interface{}) (*CommandResult, error) {
+	if err := y.checkSupport("bg_set_scene"); err != nil {
+		return nil, err
+	}
+	return y.ExecuteCommand("bg_set_scene", append([]interface{}{class}, values...))
+}
+
+// SetHSVAndBrightness sets hue (0-359), saturation (0-100) and brightness (1-100)
+// in a single command, so the bulb does not flicker between them
+func (y *Bulb) SetHSVAndBrightness(hue, saturation, brightness int) (*CommandResult, error) {
+	if hue < 0 || hue > 359 {
+		return nil, fmt.Errorf("the hue value to set (0-359), got %d", hue)
+	}
+	if saturation < 0 || saturation > 100 {
+		return nil, fmt.Errorf("the saturation value to set (0-100), got %d", saturation)
+	}
+	if !ValidBrightness(brightness) {
+		return nil, brightnessError(brightness)
+	}
+	return y.SetScene("hsv", hue, saturation, brightness)
+}
+
+// SetBoth sets colors of main and background light of dual-light models with the same brightness (1-100)
+func (y *Bulb) SetBoth(mainRGB color.RGBA, bgRGB color.RGBA, brightness int) error {
+	if !ValidBrightness(brightness) {
+		return brightnessError(brightness)
+	}
+	mainValue, bgValue := c.RGBToYeelight(mainRGB), c.RGBToYeelight(bgRGB)
+	if mainValue == 0 || bgValue == 0 {
+		return ErrInvalidColor
+	}
+	if err := y.checkSupport("bg_set_scene"); err != nil {
+		return err
+	}
+
+	if _, err := y.SetScene("color", mainValue, brightness); err != nil {
+		return err
+	}
+	_, err := y.BgSetScene("color", bgValue, brightness)
+	return err
+}
+
+// SetColorFlowScene starts the flow as a scene, turning the bulb on if it is off
+func (y *Bulb) SetColorFlowScene(flow *Flow) (*CommandResult, error) {
+	return y.SetScene("cf", flow.AsStartParams()...)
+}
+
+// TurnOnFor turns the bulb on with brightness (1-100) and turns it off after d,
+// which the bulb counts in whole minutes
+func (y *Bulb) TurnOnFor(brightness int, d time.Duration) (*CommandResult, error) {
+	if !ValidBrightness(brightness) {
+		return nil, brightnessError(brightness)
+	}
+	minutes := int(math.Round(d.Minutes()))
+	if minutes < 1 {
+		return nil, fmt.Errorf("the delay to set must be at least 1 minute, got %s", d)
+	}
+	return y.SetScene("auto_delay_off", brightness, minutes)
+}
+
+// SetDefault saves current state of the bulb as its power-on default
+func (y *Bulb) SetDefault() (*CommandResult, error) {
+	return y.ExecuteCommand("set_default")
+}
+
+// SetWhiteScene sets color temperature (1700-6500) and brightness (1-100) in a single command.
+// With persist, the state is also saved as default, so it survives a power cut
+func (y *Bulb) SetWhiteScene(ct, brightness int, persist bool) (*CommandResult, error) {
+	if ct < MinColorTemperature || ct > MaxColorTemperature {
+		return nil, fmt.Errorf("the color temperature value to set (%d-%d), got %d", MinColorTemperature, MaxColorTemperature, ct)
+	}
+	if !ValidBrightness(brightness) {
+		return nil, brightnessError(brightness)
+	}
+	res, err := y.SetScene("ct", ct, brightness)
+	if err != nil || !persist {
+		return res, err
+	}
+	return y.SetDefault()
+}
+
+// ResetToDefault turns the bulb on with 50% brightness and 4000K white and saves it as default.
+// It is a "make it normal again" helper, not a factory reset: name, flows and network settings are kept
+func (y *Bulb) ResetToDefault() error {
+	steps := []struct {
+		method string
+		params []interface{}
+	}{
+		{"set_power", []interface{}{"on"}},
+		{"set_ct_abx", []interface{}{4000, Sudden, 0}},
+		{"set_bright", []interface{}{50, Sudden, 0}},
+		{"set_default", nil},
+	}
+	for _, step := range steps {
+		if _, err := y.ExecuteCommand(step.method, step.params...); err != nil {
+			return fmt.Errorf("cannot reset bulb, %s failed. %w", step.method, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package yeelight
+
+import (
+	"errors"
+	"image/color"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSetHSVAndBrightnessSendsSingleScene(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb()
+
+	if _, err := y.SetHSVAndBrightness(120, 80, 60); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if methods := f.methods(); !reflect.DeepEqual(methods, []string{"set_scene"}) {
+		t.Fatalf("expected single set_scene, got %v", methods)
+	}
+	if got := paramsOf(f.last("set_scene")); !reflect.DeepEqual(got, []string{"hsv", "120", "80", "60"}) {
+		t.Errorf("unexpected params %v", got)
+	}
+}
+
+func TestSetHSVAndBrightnessValidatesRanges(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb()
+
+	for _, values := range [][3]int{{-1, 50, 50}, {360, 50, 50}, {0, -1, 50}, {0, 101, 50}, {0, 50, 0}, {0, 50, 101}} {
+		if _, err := y.SetHSVAndBrightness(values[0], values[1], values[2]); err == nil {
+			t.Errorf("SetHSVAndBrightness%v expected error", values)
+		}
+	}
+	if methods := f.methods(); len(methods) != 0 {
+		t.Errorf("expected nothing sent, got %v", methods)
+	}
+}
+
+func TestSetWhiteSceneValidatesRanges(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb()
+
+	for _, values := range [][2]int{{1699, 50}, {6501, 50}, {2700, 0}, {2700, 101}} {
+		if _, err := y.SetWhiteScene(values[0], values[1], true); err == nil {
+			t.Errorf("SetWhiteScene%v expected error", values)
+		}
+	}
+	if methods := f.methods(); len(methods) != 0 {
+		t.Errorf("expected nothing sent, got %v", methods)
+	}
+}
+
+func TestSetColorFlowSceneSerializesFlow(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb()
+
+	flow := NewFlow(2, Stay, nil).CTStep(2700, 500, 50).Sleep(300)
+	if _, err := y.SetColorFlowScene(flow); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	want := []string{"cf", "4", "1", "500,2,2700,50,300,7,0,0"}
+	if got := paramsOf(f.last("set_scene")); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestSetWhiteSceneSavesDefaultOnlyWhenPersisted(t *testing.T) {
+	for _, persist := range []bool{false, true} {
+		f := newFakeBulb(t)
+		y := f.newBulb()
+
+		if _, err := y.SetWhiteScene(2700, 40, persist); err != nil {
+			t.Fatalf("unexpected error %s", err)
+		}
+		want := []string{"set_scene"}
+		if persist {
+			want = append(want, "set_default")
+		}
+		if got := f.methods(); !reflect.DeepEqual(got, want) {
+			t.Errorf("persist %t sent %v, want %v", persist, got, want)
+		}
+		if got := paramsOf(f.last("set_scene")); !reflect.DeepEqual(got, []string{"ct", "2700", "40"}) {
+			t.Errorf("unexpected scene params %v", got)
+		}
+	}
+}
+
+func TestResetToDefaultSequence(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb()
+
+	if err := y.ResetToDefault(); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	var got [][]string
+	for _, cmd := range f.received() {
+		got = append(got, append([]string{cmd.Method}, paramsOf(cmd)...))
+	}
+	want := [][]string{
+		{"set_power", "on"},
+		{"set_ct_abx", "4000", "sudden", "0"},
+		{"set_bright", "50", "sudden", "0"},
+		{"set_default"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestResetToDefaultReportsFailingStep(t *testing.T) {
+	f := newFakeBulb(t)
+	f.reply(func(cmd Command) []string {
+		if cmd.Method == "set_ct_abx" {
+			return []string{errorLine(cmd.ID, -1, "general error")}
+		}
+		return nil
+	})
+	y := f.newBulb()
+
+	err := y.ResetToDefault()
+	if err == nil || !strings.Contains(err.Error(), "set_ct_abx failed") {
+		t.Fatalf("expected failing step in error, got %v", err)
+	}
+	if methods := f.methods(); !reflect.DeepEqual(methods, []string{"set_power", "set_ct_abx"}) {
+		t.Errorf("expected sequence to stop at the failing step, got %v", methods)
+	}
+}
+
+func TestSetBothSetsMainAndBackgroundLight(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb()
+
+	if err := y.SetBoth(color.RGBA{R: 255, A: 255}, color.RGBA{B: 255, A: 255}, 60); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if got := paramsOf(f.last("set_scene")); !reflect.DeepEqual(got, []string{"color", "16711680", "60"}) {
+		t.Errorf("unexpected main scene %v", got)
+	}
+	if got := paramsOf(f.last("bg_set_scene")); !reflect.DeepEqual(got, []string{"color", "255", "60"}) {
+		t.Errorf("unexpected background scene %v", got)
+	}
+}
+
+func TestSetBothNeedsBackgroundLight(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.discoveredBulb(YeelightParams{ID: "0x1", Support: []string{"get_prop", "set_scene"}})
+
+	if err := y.SetBoth(color.RGBA{R: 255, A: 255}, color.RGBA{B: 255, A: 255}, 60); !errors.Is(err, ErrUnsupportedMethod) {
+		t.Fatalf("expected ErrUnsupportedMethod, got %v", err)
+	}
+	if methods := f.methods(); len(methods) != 0 {
+		t.Errorf("expected nothing sent, got %v", methods)
+	}
+}
+
+func TestBackgroundSceneAndFlowMethods(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb()
+
+	if _, err := y.BgSetScene("ct", 3000, 40); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if _, err := y.BgStartFlow(NewFlow(2, Stay, nil).CTStep(2700, 500, 50)); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if _, err := y.BgStopFlow(); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	if methods := f.methods(); !reflect.DeepEqual(methods, []string{"bg_set_scene", "bg_start_cf", "bg_stop_cf"}) {
+		t.Fatalf("unexpected methods %v", methods)
+	}
+	if got := paramsOf(f.last("bg_set_scene")); !reflect.DeepEqual(got, []string{"ct", "3000", "40"}) {
+		t.Errorf("unexpected scene params %v", got)
+	}
+	if got := paramsOf(f.last("bg_start_cf")); !reflect.DeepEqual(got, []string{"2", "1", "500,2,2700,50"}) {
+		t.Errorf("unexpected flow params %v", got)
+	}
+}
+
+func TestBackgroundMethodsNeedSupport(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.discoveredBulb(YeelightParams{ID: "0x1", Support: []string{"get_prop", "set_scene", "start_cf", "stop_cf"}})
+
+	if _, err := y.BgSetScene("ct", 3000, 40); !errors.Is(err, ErrUnsupportedMethod) {
+		t.Errorf("BgSetScene expected ErrUnsupportedMethod, got %v", err)
+	}
+	if _, err := y.BgStartFlow(NewFlow(1, Stay, nil).Sleep(500)); !errors.Is(err, ErrUnsupportedMethod) {
+		t.Errorf("BgStartFlow expected ErrUnsupportedMethod, got %v", err)
+	}
+	if _, err := y.BgStopFlow(); !errors.Is(err, ErrUnsupportedMethod) {
+		t.Errorf("BgStopFlow expected ErrUnsupportedMethod, got %v", err)
+	}
+	if methods := f.methods(); len(methods) != 0 {
+		t.Errorf("expected nothing sent, got %v", methods)
+	}
+}
+
+func TestTurnOnForSendsAutoDelayOffScene(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb()
+
+	// the delay is rounded to whole minutes
+	if _, err := y.TurnOnFor(30, 90*time.Minute+20*time.Second); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if got := paramsOf(f.last("set_scene")); !reflect.DeepEqual(got, []string{"auto_delay_off", "30", "90"}) {
+		t.Errorf("unexpected params %v", got)
+	}
+}
+
+func TestTurnOnForValidatesArguments(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb()
+
+	if _, err := y.TurnOnFor(0, time.Hour); err == nil {
+		t.Error("expected invalid brightness to be rejected")
+	}
+	if _, err := y.TurnOnFor(50, 20*time.Second); err == nil {
+		t.Error("expected delay below a minute to be rejected")
+	}
+	if methods := f.methods(); len(methods) != 0 {
+		t.Errorf("expected nothing sent, got %v", methods)
+	}
+}
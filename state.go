@@ -0,0 +1,310 @@
+package yeelight
+
+import (
+	"context"
+	"fmt"
+	c "github.com/akominch/yeelight/color"
+	"github.com/akominch/yeelight/utils"
+	"image/color"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ColorMode is color mode reported by the bulb in color_mode prop
+type ColorMode int
+
+const (
+	ColorModeRGB ColorMode = 1
+	ColorModeCT  ColorMode = 2
+	ColorModeHSV ColorMode = 3
+)
+
+// ActiveMode is active_mode prop reported by ceiling lamps
+type ActiveMode int
+
+const (
+	// ActiveModeUnknown is used for bulbs which do not report active_mode
+	ActiveModeUnknown   ActiveMode = -1
+	ActiveModeDaylight  ActiveMode = 0
+	ActiveModeMoonlight ActiveMode = 1
+)
+
+// HSVColor is hue (0-359) and saturation (0-100) pair of the color
+type HSVColor struct {
+	Hue int
+	Sat int
+}
+
+// State represents current state of the bulb
+type State struct {
+	Power     bool
+	Bright    int
+	ColorMode ColorMode
+	CT        int
+	RGB       color.RGBA
+	Hue       int
+	Sat       int
+	// HSV combines Hue and Sat, it is set only in ColorModeHSV
+	HSV        HSVColor
+	Name       string
+	Flowing    bool
+	MusicOn    bool
+	DelayOff   int
+	ActiveMode ActiveMode
+}
+
+// BackgroundState represents state of the background light of dual-light models
+type BackgroundState struct {
+	Power     bool
+	Bright    int
+	ColorMode ColorMode
+	CT        int
+	RGB       color.RGBA
+	Hue       int
+	Sat       int
+}
+
+// backgroundProps lists props requested by GetBackgroundState
+var backgroundProps = []string{"bg_power", "bg_bright", "bg_lmode", "bg_ct", "bg_rgb", "bg_hue", "bg_sat"}
+
+// knownProps is set of props defined by Yeelight protocol
+var knownProps = map[string]bool{
+	"power": true, "bright": true, "ct": true, "rgb": true, "hue": true, "sat": true,
+	"color_mode": true, "flowing": true, "delayoff": true, "flow_params": true,
+	"music_on": true, "name": true, "bg_power": true, "bg_flowing": true,
+	"bg_flow_params": true, "bg_ct": true, "bg_lmode": true, "bg_bright": true,
+	"bg_rgb": true, "bg_hue": true, "bg_sat": true, "nl_br": true, "active_mode": true,
+}
+
+// numericProps is set of props with integer values
+var numericProps = map[string]bool{
+	"bright": true, "ct": true, "rgb": true, "hue": true, "sat": true, "color_mode": true,
+	"flowing": true, "delayoff": true, "music_on": true, "active_mode": true, "nl_br": true,
+	"bg_flowing": true, "bg_ct": true, "bg_lmode": true, "bg_bright": true, "bg_rgb": true,
+	"bg_hue": true, "bg_sat": true,
+}
+
+// checkProps returns error for numeric prop with value which is not a number.
+// Empty values are accepted, the bulb returns them for unsupported props
+func checkProps(props map[string]string) error {
+	for key, value := range props {
+		if numericProps[key] && value != "" {
+			if _, err := parseIntProp(value); err != nil {
+				return fmt.Errorf("invalid %s value %q", key, value)
+			}
+		}
+	}
+	return nil
+}
+
+// KnownProps returns names of all props defined by Yeelight protocol
+func KnownProps() []string {
+	props := make([]string, 0, len(knownProps))
+	for prop := range knownProps {
+		props = append(props, prop)
+	}
+	sort.Strings(props)
+	return props
+}
+
+// stateProps lists props requested by GetState
+var stateProps = []string{"power", "bright", "color_mode", "ct", "rgb", "hue", "sat", "name", "flowing", "music_on", "delayoff", "active_mode"}
+
+// GetState reads current state of the bulb
+func (y *Bulb) GetState() (*State, error) {
+	res, err := y.GetProps(stateProps)
+	if err != nil {
+		return nil, err
+	}
+	if y.strict {
+		if err := checkProps(res.Result); err != nil {
+			return nil, err
+		}
+	}
+	y.updateLive(res.Result)
+	state := parseState(res.Result)
+	y.reconcileMusicMode(state.MusicOn)
+
+	return state, nil
+}
+
+// CurrentState returns the last known state without a network round trip, built from GetState
+// and notifications of active listeners. It reports false when the state may be outdated,
+// because no listener is running. Nil is returned when nothing is known yet
+func (y *Bulb) CurrentState() (*State, bool) {
+	y.liveMu.Lock()
+	defer y.liveMu.Unlock()
+
+	if y.liveProps == nil {
+		return nil, false
+	}
+	return parseState(y.liveProps), y.liveListeners > 0
+}
+
+// updateLive merges props into the cached state
+func (y *Bulb) updateLive(props map[string]string) {
+	y.liveMu.Lock()
+	defer y.liveMu.Unlock()
+
+	if y.liveProps == nil {
+		y.liveProps = make(map[string]string, len(props))
+	}
+	for key, value := range props {
+		y.liveProps[key] = value
+	}
+}
+
+// addLiveListener changes number of listeners keeping cached state up to date
+func (y *Bulb) addLiveListener(delta int) {
+	y.liveMu.Lock()
+	defer y.liveMu.Unlock()
+
+	y.liveListeners += delta
+}
+
+// GetBackgroundState reads current state of the background light
+func (y *Bulb) GetBackgroundState() (*BackgroundState, error) {
+	res, err := y.GetProps(backgroundProps)
+	if err != nil {
+		return nil, err
+	}
+	if y.strict {
+		if err := checkProps(res.Result); err != nil {
+			return nil, err
+		}
+	}
+	return parseBackgroundState(res.Result), nil
+}
+
+// Snapshot captures current state of the bulb, so it can be put back with Restore
+func (y *Bulb) Snapshot() (*State, error) {
+	return y.GetState()
+}
+
+// Restore applies captured state: power first, then color, color temperature or hsv
+// depending on the color mode and brightness last
+func (y *Bulb) Restore(ctx context.Context, s *State) error {
+	if !s.Power {
+		_, err := y.TurnOff()
+		return err
+	}
+
+	cmds := [][]interface{}{{"set_power", "on"}}
+	switch s.ColorMode {
+	case ColorModeRGB:
+		cmds = append(cmds, []interface{}{"set_rgb", c.RGBToYeelight(s.RGB), y.currentEffect()})
+	case ColorModeCT:
+		cmds = append(cmds, []interface{}{"set_ct_abx", s.CT, y.currentEffect()})
+	case ColorModeHSV:
+		cmds = append(cmds, []interface{}{"set_hsv", s.Hue, s.Sat, y.currentEffect()})
+	}
+	cmds = append(cmds, []interface{}{"set_bright", utils.GetBrightnessValue(s.Bright), y.currentEffect()})
+
+	for _, cmd := range cmds {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, err := y.ExecuteCommand(cmd[0].(string), cmd[1:]...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetDefaultState makes given state the power-on default. The state is applied and saved
+// with set_default right away, so it briefly shows on the bulb before the previous one is restored
+func (y *Bulb) SetDefaultState(s *State) error {
+	current, err := y.Snapshot()
+	if err != nil {
+		return err
+	}
+	if err := y.Restore(context.Background(), s); err != nil {
+		return err
+	}
+	if _, err := y.SetDefault(); err != nil {
+		return err
+	}
+	return y.Restore(context.Background(), current)
+}
+
+// parseState converts get_prop values to State, ignoring values which cannot be parsed
+func parseState(props map[string]string) *State {
+	rgb, _ := parseIntProp(props["rgb"])
+	s := &State{
+		Power:   props["power"] == "on",
+		RGB:     c.YeelightToRGB(rgb),
+		Name:    props["name"],
+		Flowing: props["flowing"] == "1",
+		MusicOn: props["music_on"] == "1",
+	}
+	s.Bright, _ = parseIntProp(props["bright"])
+	colorMode, _ := parseIntProp(props["color_mode"])
+	s.ColorMode = ColorMode(colorMode)
+	s.CT, _ = parseIntProp(props["ct"])
+	s.Hue, _ = parseIntProp(props["hue"])
+	s.Sat, _ = parseIntProp(props["sat"])
+	if s.ColorMode == ColorModeHSV {
+		s.HSV = HSVColor{Hue: s.Hue, Sat: s.Sat}
+	}
+	s.DelayOff, _ = parseIntProp(props["delayoff"])
+	s.ActiveMode, _ = parseActiveMode(props["active_mode"])
+
+	return s
+}
+
+// parseBackgroundState converts bg_* get_prop values to BackgroundState
+func parseBackgroundState(props map[string]string) *BackgroundState {
+	rgb, _ := parseIntProp(props["bg_rgb"])
+	s := &BackgroundState{
+		Power: props["bg_power"] == "on",
+		RGB:   c.YeelightToRGB(rgb),
+	}
+	s.Bright, _ = parseIntProp(props["bg_bright"])
+	colorMode, _ := parseIntProp(props["bg_lmode"])
+	s.ColorMode = ColorMode(colorMode)
+	s.CT, _ = parseIntProp(props["bg_ct"])
+	s.Hue, _ = parseIntProp(props["bg_hue"])
+	s.Sat, _ = parseIntProp(props["bg_sat"])
+
+	return s
+}
+
+// parseIntProp parses numeric prop, which firmwares return either as number or as quoted string
+func parseIntProp(value string) (int, error) {
+	value = strings.Trim(value, "\"")
+	if i, err := strconv.Atoi(value); err == nil {
+		return i, nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, err
+	}
+	return int(f), nil
+}
+
+// IsMoonlight reports whether ceiling lamp is in moonlight mode, false for bulbs without active_mode
+func (y *Bulb) IsMoonlight() (bool, error) {
+	res, err := y.GetProps([]string{"active_mode"})
+	if err != nil {
+		return false, err
+	}
+
+	mode, err := parseActiveMode(res.Result["active_mode"])
+	if err != nil {
+		return false, err
+	}
+	return mode == ActiveModeMoonlight, nil
+}
+
+// parseActiveMode converts active_mode value, empty value of bulbs without the prop is ActiveModeUnknown
+func parseActiveMode(value string) (ActiveMode, error) {
+	if value == "" {
+		return ActiveModeUnknown, nil
+	}
+	mode, err := parseIntProp(value)
+	if err != nil {
+		return ActiveModeUnknown, fmt.Errorf("invalid active_mode value %q", value)
+	}
+	return ActiveMode(mode), nil
+}
@@ -0,0 +1,343 @@
+package yeelight
+
+import (
+	"context"
+	"fmt"
+	"image/color"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestParseStateActiveMode(t *testing.T) {
+	tests := []struct {
+		value string
+		want  ActiveMode
+	}{
+		{"0", ActiveModeDaylight},
+		{"1", ActiveModeMoonlight},
+		{"", ActiveModeUnknown},
+		{"x", ActiveModeUnknown},
+	}
+	for _, tt := range tests {
+		state := parseState(map[string]string{"active_mode": tt.value})
+		if state.ActiveMode != tt.want {
+			t.Errorf("active_mode %q parsed as %d, want %d", tt.value, state.ActiveMode, tt.want)
+		}
+	}
+}
+
+func TestIsMoonlight(t *testing.T) {
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{"0", false},
+		{"1", true},
+		{"", false},
+	}
+	for _, tt := range tests {
+		f := newFakeBulb(t)
+		f.setProps(map[string]string{"active_mode": tt.value})
+		y := f.newBulb()
+
+		moonlight, err := y.IsMoonlight()
+		if err != nil {
+			t.Fatalf("unexpected error %s", err)
+		}
+		if moonlight != tt.want {
+			t.Errorf("active_mode %q reported moonlight %t, want %t", tt.value, moonlight, tt.want)
+		}
+	}
+}
+
+func TestIsMoonlightReportsMalformedValue(t *testing.T) {
+	f := newFakeBulb(t)
+	f.setProps(map[string]string{"active_mode": "moon"})
+	y := f.newBulb()
+
+	if _, err := y.IsMoonlight(); err == nil || !strings.Contains(err.Error(), `invalid active_mode value "moon"`) {
+		t.Errorf("expected invalid active_mode error, got %v", err)
+	}
+}
+
+func TestSnapshotRestoreRoundTrip(t *testing.T) {
+	f := newFakeBulb(t)
+	f.setProps(map[string]string{"power": "on", "color_mode": "1", "rgb": "16711680", "bright": "40"})
+	y := f.newBulb()
+
+	state, err := y.Snapshot()
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	before := len(f.received())
+	if err := y.Restore(context.Background(), state); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	var got [][]string
+	for _, cmd := range f.received()[before:] {
+		got = append(got, append([]string{cmd.Method}, paramsOf(cmd)...))
+	}
+	want := [][]string{
+		{"set_power", "on"},
+		{"set_rgb", "16711680", "smooth"},
+		{"set_bright", "40", "smooth"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestRestoreTurnsOffBulbCapturedOff(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb()
+
+	if err := y.Restore(context.Background(), &State{Power: false, Bright: 40}); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if methods := f.methods(); !reflect.DeepEqual(methods, []string{"set_power"}) {
+		t.Fatalf("expected only set_power, got %v", methods)
+	}
+	if got := paramsOf(f.last("set_power"))[0]; got != "off" {
+		t.Errorf("expected set_power off, got %s", got)
+	}
+}
+
+func TestRestoreStopsWhenContextIsCancelled(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := y.Restore(ctx, &State{Power: true, ColorMode: ColorModeCT, CT: 4000, Bright: 40}); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if methods := f.methods(); len(methods) != 0 {
+		t.Errorf("expected nothing sent, got %v", methods)
+	}
+}
+
+func TestKnownPropsSorted(t *testing.T) {
+	props := KnownProps()
+	if len(props) != len(knownProps) {
+		t.Fatalf("expected %d props, got %d", len(knownProps), len(props))
+	}
+	if !sort.StringsAreSorted(props) {
+		t.Errorf("expected sorted props, got %v", props)
+	}
+}
+
+func TestGetPropsReportsUnknownProp(t *testing.T) {
+	f := newFakeBulb(t)
+	var unknown []string
+	y := f.newBulb(WithUnknownPropHook(func(prop string) {
+		unknown = append(unknown, prop)
+	}))
+
+	if _, err := y.GetProps([]string{"power", "brigth"}); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if !reflect.DeepEqual(unknown, []string{"brigth"}) {
+		t.Errorf("expected hook called with the typo, got %v", unknown)
+	}
+}
+
+func TestGetStateParsesRGBAsNumberOrString(t *testing.T) {
+	for _, rgb := range []string{`16711680`, `"16711680"`} {
+		f := newFakeBulb(t)
+		f.reply(func(cmd Command) []string {
+			if cmd.Method != "get_prop" {
+				return nil
+			}
+			values := make([]string, len(cmd.Params))
+			for i, param := range cmd.Params {
+				values[i] = `""`
+				if param == "rgb" {
+					values[i] = rgb
+				}
+			}
+			return []string{fmt.Sprintf(`{"id":%d,"result":[%s]}`, cmd.ID, strings.Join(values, ","))}
+		})
+		y := f.newBulb()
+
+		state, err := y.GetState()
+		if err != nil {
+			t.Fatalf("unexpected error %s", err)
+		}
+		if want := (color.RGBA{R: 255, A: 255}); state.RGB != want {
+			t.Errorf("rgb %s parsed to %v, want %v", rgb, state.RGB, want)
+		}
+	}
+}
+
+func TestParseIntProp(t *testing.T) {
+	for _, value := range []string{"255", `"255"`, "255.0"} {
+		if got, err := parseIntProp(value); err != nil || got != 255 {
+			t.Errorf("parseIntProp(%s) = %d, %v", value, got, err)
+		}
+	}
+	if _, err := parseIntProp("red"); err == nil {
+		t.Error("expected error for non numeric prop")
+	}
+}
+
+func TestSetDefaultStateAppliesSavesAndRestores(t *testing.T) {
+	f := newFakeBulb(t)
+	f.setProps(map[string]string{"power": "on", "color_mode": "2", "ct": "4000", "bright": "50"})
+	y := f.newBulb()
+
+	if err := y.SetDefaultState(&State{Power: true, ColorMode: ColorModeCT, CT: 2700, Bright: 20}); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	var got [][]string
+	for _, cmd := range f.received() {
+		got = append(got, append([]string{cmd.Method}, paramsOf(cmd)...))
+	}
+	want := [][]string{
+		{"get_prop", "power", "bright", "color_mode", "ct", "rgb", "hue", "sat", "name", "flowing", "music_on", "delayoff", "active_mode"},
+		{"set_power", "on"},
+		{"set_ct_abx", "2700", "smooth"},
+		{"set_bright", "20", "smooth"},
+		{"set_default"},
+		{"set_power", "on"},
+		{"set_ct_abx", "4000", "smooth"},
+		{"set_bright", "50", "smooth"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestSetDefaultStateStopsWhenApplyFails(t *testing.T) {
+	f := newFakeBulb(t)
+	f.reply(func(cmd Command) []string {
+		if cmd.Method == "set_bright" {
+			return []string{errorLine(cmd.ID, -1, "general error")}
+		}
+		return nil
+	})
+	y := f.newBulb()
+
+	if err := y.SetDefaultState(&State{Power: true, Bright: 20}); err == nil {
+		t.Fatal("expected error")
+	}
+	for _, method := range f.methods() {
+		if method == "set_default" {
+			t.Fatal("set_default was sent although the state was not applied")
+		}
+	}
+}
+
+func TestGetBackgroundStateParsesAllProps(t *testing.T) {
+	f := newFakeBulb(t)
+	f.setProps(map[string]string{
+		"power": "off", "bright": "90",
+		"bg_power": "on", "bg_bright": "40", "bg_lmode": "2", "bg_ct": "3000",
+		"bg_rgb": "65280", "bg_hue": "120", "bg_sat": "80",
+	})
+	y := f.newBulb()
+
+	state, err := y.GetBackgroundState()
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	want := &BackgroundState{
+		Power: true, Bright: 40, ColorMode: ColorModeCT, CT: 3000,
+		RGB: color.RGBA{G: 255, A: 255}, Hue: 120, Sat: 80,
+	}
+	if !reflect.DeepEqual(state, want) {
+		t.Errorf("expected %+v, got %+v", want, state)
+	}
+	if got := paramsOf(f.last("get_prop")); !reflect.DeepEqual(got, backgroundProps) {
+		t.Errorf("expected only background props requested, got %v", got)
+	}
+}
+
+func TestStrictGetStateRejectsMalformedValue(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		f := newFakeBulb(t)
+		f.setProps(map[string]string{"power": "on", "bright": "bright"})
+		y := f.newBulb(WithStrict(strict))
+
+		state, err := y.GetState()
+		if strict {
+			if err == nil || !strings.Contains(err.Error(), "invalid bright") {
+				t.Errorf("expected invalid bright error in strict mode, got %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("unexpected error in lenient mode %s", err)
+		}
+		if state.Bright != 0 || !state.Power {
+			t.Errorf("expected malformed bright zeroed, got %+v", state)
+		}
+	}
+}
+
+func TestParseStateHSV(t *testing.T) {
+	tests := []struct {
+		colorMode string
+		want      HSVColor
+	}{
+		{"3", HSVColor{Hue: 240, Sat: 70}},
+		{"1", HSVColor{}},
+		{"2", HSVColor{}},
+	}
+	for _, tt := range tests {
+		state := parseState(map[string]string{"color_mode": tt.colorMode, "hue": "240", "sat": "70"})
+		if state.HSV != tt.want {
+			t.Errorf("color mode %s parsed HSV %+v, want %+v", tt.colorMode, state.HSV, tt.want)
+		}
+		if state.Hue != 240 || state.Sat != 70 {
+			t.Errorf("color mode %s lost raw hue and sat, got %d %d", tt.colorMode, state.Hue, state.Sat)
+		}
+	}
+}
+
+func TestCurrentStateIsNilBeforeAnythingIsKnown(t *testing.T) {
+	y := NewWithOptions("127.0.0.1")
+
+	if state, live := y.CurrentState(); state != nil || live {
+		t.Errorf("expected nothing known, got %+v %t", state, live)
+	}
+}
+
+func TestCurrentStateFollowsGetStateAndNotifications(t *testing.T) {
+	f := newFakeBulb(t)
+	f.setProps(map[string]string{"power": "on", "bright": "40"})
+	y := f.newBulb()
+
+	if _, err := y.GetState(); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	state, live := y.CurrentState()
+	if state == nil || !state.Power || state.Bright != 40 || live {
+		t.Fatalf("expected cached state without listener, got %+v %t", state, live)
+	}
+
+	l, err := y.NewListener()
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	// the listener is the only open connection once get_prop connection is closed
+	eventually(t, "listener connected", func() bool {
+		return f.acceptedConns() == 2 && f.openConns() == 1
+	})
+	f.push(propsLine(map[string]string{"bright": "70"}))
+	nextNotification(t, l)
+
+	state, live = y.CurrentState()
+	if !state.Power || state.Bright != 70 || !live {
+		t.Errorf("expected notification merged into live state, got %+v %t", state, live)
+	}
+
+	l.Close()
+	eventually(t, "listener to stop", func() bool {
+		_, live := y.CurrentState()
+		return !live
+	})
+}
@@ -0,0 +1,215 @@
+// Package testutil helps reproducing bulb sessions without a real bulb
+package testutil
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Prefixes of lines written by Bulb.RecordTo
+const (
+	sentPrefix     = "> "
+	receivedPrefix = "< "
+)
+
+// step is recorded command with lines the bulb answered
+type step struct {
+	command string
+	replies []string
+}
+
+// ReplayServer is fake bulb answering commands with lines recorded by Bulb.RecordTo.
+// Reply ids are rewritten to ids of received commands. Commands have to arrive in recorded
+// order, mismatches are reported by Err. Music mode sessions cannot be replayed
+type ReplayServer struct {
+	listener net.Listener
+	steps    []step
+
+	mu    sync.Mutex
+	next  int
+	err   error
+	conns map[net.Conn]bool
+	wg    sync.WaitGroup
+}
+
+// NewReplayServer reads recording and starts serving it on a random local port
+func NewReplayServer(recording io.Reader) (*ReplayServer, error) {
+	steps, err := parseRecording(recording)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("cannot start replay server %s", err)
+	}
+
+	s := &ReplayServer{listener: listener, steps: steps, conns: make(map[net.Conn]bool)}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// parseRecording groups recorded lines into steps
+func parseRecording(r io.Reader) ([]step, error) {
+	var steps []step
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, sentPrefix):
+			steps = append(steps, step{command: strings.TrimPrefix(line, sentPrefix)})
+		case strings.HasPrefix(line, receivedPrefix):
+			if len(steps) == 0 {
+				return nil, fmt.Errorf("recording starts with received line %q", line)
+			}
+			last := &steps[len(steps)-1]
+			last.replies = append(last.replies, strings.TrimPrefix(line, receivedPrefix))
+		case strings.TrimSpace(line) == "":
+		default:
+			return nil, fmt.Errorf("unexpected recording line %q", line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("cannot read recording %s", err)
+	}
+	return steps, nil
+}
+
+// Host returns ip the server listens on
+func (s *ReplayServer) Host() string {
+	return s.listener.Addr().(*net.TCPAddr).IP.String()
+}
+
+// Port returns port the server listens on, pass it to yeelight.WithPort
+func (s *ReplayServer) Port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+// Err returns the first mismatch between received and recorded commands
+func (s *ReplayServer) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.err
+}
+
+// Done reports whether all recorded commands were received
+func (s *ReplayServer) Done() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.next == len(s.steps)
+}
+
+// Close stops the server and closes connections of clients
+func (s *ReplayServer) Close() error {
+	err := s.listener.Close()
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+func (s *ReplayServer) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = true
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go s.handle(conn)
+	}
+}
+
+// handle answers commands received on a single connection
+func (s *ReplayServer) handle(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		replies, err := s.answer(strings.TrimSpace(line))
+		if err != nil {
+			s.fail(err)
+			return
+		}
+		for _, reply := range replies {
+			if _, err := fmt.Fprint(conn, reply+"\r\n"); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// answer matches received command with the next recorded one and returns its replies
+func (s *ReplayServer) answer(line string) ([]string, error) {
+	var received, recorded map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &received); err != nil {
+		return nil, fmt.Errorf("cannot parse command %q %s", line, err)
+	}
+
+	s.mu.Lock()
+	if s.next >= len(s.steps) {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("unexpected command %s, recording is finished", line)
+	}
+	st := s.steps[s.next]
+	s.next++
+	s.mu.Unlock()
+
+	if err := json.Unmarshal([]byte(st.command), &recorded); err != nil {
+		return nil, fmt.Errorf("cannot parse recorded command %q %s", st.command, err)
+	}
+	if received["method"] != recorded["method"] || !reflect.DeepEqual(received["params"], recorded["params"]) {
+		return nil, fmt.Errorf("command %s does not match recorded %s", line, st.command)
+	}
+
+	replies := make([]string, len(st.replies))
+	for i, reply := range st.replies {
+		replies[i] = withID(reply, recorded["id"], received["id"])
+	}
+	return replies, nil
+}
+
+// withID replaces id of reply to the recorded command with id of the received one
+func withID(reply string, recordedID, receivedID interface{}) string {
+	var msg map[string]interface{}
+	if err := json.Unmarshal([]byte(reply), &msg); err != nil || msg["id"] != recordedID {
+		return reply
+	}
+	msg["id"] = receivedID
+	b, _ := json.Marshal(msg)
+	return string(b)
+}
+
+// fail remembers the first mismatch
+func (s *ReplayServer) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err == nil {
+		s.err = err
+	}
+}
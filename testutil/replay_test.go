@@ -0,0 +1,140 @@
+package testutil
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const recording = `> {"id":1,"method":"set_power","params":["on","smooth",500]}
+< {"id":1,"result":["ok"]}
+< {"method":"props","params":{"power":"on"}}
+
+> {"id":2,"method":"get_prop","params":["bright"]}
+< {"id":2,"result":["50"]}
+`
+
+// dial connects to the server
+func dial(t *testing.T, s *ReplayServer) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", net.JoinHostPort(s.Host(), strconv.Itoa(s.Port())))
+	if err != nil {
+		t.Fatalf("cannot connect to replay server %s", err)
+	}
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	return conn, bufio.NewReader(conn)
+}
+
+// readLine reads a single trimmed line
+func readLine(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+	line, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatalf("cannot read reply %s", err)
+	}
+	return strings.TrimSpace(line)
+}
+
+func TestParseRecording(t *testing.T) {
+	steps, err := parseRecording(strings.NewReader(recording))
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if len(steps) != 2 || len(steps[0].replies) != 2 || len(steps[1].replies) != 1 {
+		t.Fatalf("expected 2 steps with 2 and 1 replies, got %+v", steps)
+	}
+	if steps[1].command != `{"id":2,"method":"get_prop","params":["bright"]}` {
+		t.Errorf("unexpected command %s", steps[1].command)
+	}
+}
+
+func TestParseRecordingRejectsInvalidLines(t *testing.T) {
+	if _, err := parseRecording(strings.NewReader(`< {"id":1,"result":["ok"]}`)); err == nil || !strings.Contains(err.Error(), "starts with received line") {
+		t.Errorf("expected error for leading received line, got %v", err)
+	}
+	if _, err := parseRecording(strings.NewReader("garbage")); err == nil {
+		t.Error("expected error for unexpected line")
+	}
+}
+
+func TestWithID(t *testing.T) {
+	if got := withID(`{"id":1,"result":["ok"]}`, float64(1), float64(7)); got != `{"id":7,"result":["ok"]}` {
+		t.Errorf("expected id rewritten, got %s", got)
+	}
+	notification := `{"method":"props","params":{"power":"on"}}`
+	if got := withID(notification, float64(1), float64(7)); got != notification {
+		t.Errorf("expected notification kept, got %s", got)
+	}
+}
+
+func TestReplayServerAnswersRecordedCommands(t *testing.T) {
+	s, err := NewReplayServer(strings.NewReader(recording))
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	defer s.Close()
+	conn, r := dial(t, s)
+	defer conn.Close()
+
+	fmt.Fprint(conn, `{"id":11,"method":"set_power","params":["on","smooth",500]}`+"\r\n")
+	if got := readLine(t, r); got != `{"id":11,"result":["ok"]}` {
+		t.Errorf("expected reply with received id, got %s", got)
+	}
+	if got := readLine(t, r); got != `{"method":"props","params":{"power":"on"}}` {
+		t.Errorf("expected recorded notification, got %s", got)
+	}
+	if s.Done() {
+		t.Error("expected server not done after the first command")
+	}
+
+	fmt.Fprint(conn, `{"id":12,"method":"get_prop","params":["bright"]}`+"\r\n")
+	if got := readLine(t, r); got != `{"id":12,"result":["50"]}` {
+		t.Errorf("expected reply with received id, got %s", got)
+	}
+	if !s.Done() || s.Err() != nil {
+		t.Errorf("expected server done without error, got %t %v", s.Done(), s.Err())
+	}
+}
+
+func TestReplayServerReportsMismatch(t *testing.T) {
+	s, err := NewReplayServer(strings.NewReader(recording))
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	defer s.Close()
+	conn, r := dial(t, s)
+	defer conn.Close()
+
+	fmt.Fprint(conn, `{"id":1,"method":"set_power","params":["off","smooth",500]}`+"\r\n")
+	// the server drops the connection on mismatch
+	if _, err := r.ReadString('\n'); err == nil {
+		t.Fatal("expected connection closed")
+	}
+	if err := s.Err(); err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Errorf("expected mismatch error, got %v", err)
+	}
+}
+
+func TestReplayServerCloseDisconnectsClients(t *testing.T) {
+	s, err := NewReplayServer(strings.NewReader(recording))
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	conn, r := dial(t, s)
+	defer conn.Close()
+	// make sure the connection is accepted before closing
+	fmt.Fprint(conn, `{"id":1,"method":"set_power","params":["on","smooth",500]}`+"\r\n")
+	readLine(t, r)
+	readLine(t, r)
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if _, err := r.ReadString('\n'); err == nil {
+		t.Error("expected connection closed by Close")
+	}
+}
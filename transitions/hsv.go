@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"github.com/akominch/yeelight/color"
 	"github.com/akominch/yeelight/utils"
-	"github.com/lucasb-eyer/go-colorful"
 	color2 "image/color"
 	"strconv"
 )
@@ -48,10 +47,5 @@ func (t *HSV) ChangeDuration(duration int) {
 }
 
 func convertHSVToRGB(hue int, saturation int) color2.RGBA {
-	color := colorful.Hsv(float64(hue), float64(saturation), 1)
-	return color2.RGBA{
-		R: uint8(color.R),
-		G: uint8(color.G),
-		B: uint8(color.B),
-	}
+	return color.HSVToRGB(hue, saturation)
 }
\ No newline at end of file
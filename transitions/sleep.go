@@ -19,7 +19,8 @@ func NewSleepTransition(duration int) *Sleep {
 }
 
 func (t *Sleep) AsYeelightParams() string {
-	return fmt.Sprintf("%s,%s,1,2", strconv.Itoa(t.duration), strconv.Itoa(t.mode))
+	//value and brightness are ignored by the bulb in sleep mode
+	return fmt.Sprintf("%s,%s,0,0", strconv.Itoa(t.duration), strconv.Itoa(t.mode))
 }
 
 func (t *Sleep) ChangeDuration(duration int) {
@@ -1,27 +1,11 @@
 package yeelight
 
 import (
-	"bufio"
 	"fmt"
+	"math"
 	"net"
-	"net/http"
-	"strings"
 )
 
-//parseAddr parses address from ssdp response
-func parseAddr(msg string) string {
-	if strings.HasSuffix(msg, crlf) {
-		msg = msg + crlf
-	}
-	resp, err := http.ReadResponse(bufio.NewReader(strings.NewReader(msg)), nil)
-	if err != nil {
-		fmt.Println(err)
-		return ""
-	}
-	defer resp.Body.Close()
-	return strings.TrimPrefix(resp.Header.Get("LOCATION"), "yeelight://")
-}
-
 //closeConnection closes network connection
 func closeConnection(c net.Conn) {
 	if nil != c {
@@ -29,6 +13,55 @@ func closeConnection(c net.Conn) {
 	}
 }
 
-func checkBrightnessValue(b int) bool {
-	return b < 0 || b > 100
+// Brightness limits of the bulb
+const (
+	MinBrightness = 1
+	MaxBrightness = 100
+)
+
+// ValidBrightness reports whether brightness is within MinBrightness-MaxBrightness
+func ValidBrightness(b int) bool {
+	return b >= MinBrightness && b <= MaxBrightness
+}
+
+// brightnessError describes brightness out of range
+func brightnessError(b int) error {
+	return fmt.Errorf("the brightness value to set (%d-%d), got %d", MinBrightness, MaxBrightness, b)
+}
+
+// Color temperature limits of the bulb in Kelvin
+const (
+	MinColorTemperature = 1700
+	MaxColorTemperature = 6500
+
+	// ctGranularity is step the bulb color temperature is reliably set with
+	ctGranularity = 100
+)
+
+// NearestCT clamps Kelvin to MinColorTemperature-MaxColorTemperature and snaps it to 100K steps
+func NearestCT(k int) int {
+	k = (k + ctGranularity/2) / ctGranularity * ctGranularity
+	if k < MinColorTemperature {
+		return MinColorTemperature
+	}
+	if k > MaxColorTemperature {
+		return MaxColorTemperature
+	}
+	return k
+}
+
+// KelvinToMired converts color temperature in Kelvin to mired (micro reciprocal degrees), zero for non-positive k
+func KelvinToMired(k int) int {
+	if k <= 0 {
+		return 0
+	}
+	return int(math.Round(1e6 / float64(k)))
+}
+
+// MiredToKelvin converts color temperature in mired to Kelvin, zero for non-positive m
+func MiredToKelvin(m int) int {
+	if m <= 0 {
+		return 0
+	}
+	return int(math.Round(1e6 / float64(m)))
 }
@@ -1,9 +1,17 @@
 package utils
 
+import "math"
+
 func GetBrightnessValue(brightness int) int {
 	return GetValue(brightness, 1, 100)
 }
 
+// GetBrightnessPercentValue converts 0.0-1.0 fraction to the bulb 1-100 brightness scale,
+// the fraction is clamped before conversion and NaN maps to the dimmest value
+func GetBrightnessPercentValue(percent float64) int {
+	return GetBrightnessValue(int(math.Round(clampFraction(percent) * 100)))
+}
+
 func GetSaturationValue(saturation int) int {
 	return GetValue(saturation, 1, 100)
 }
@@ -16,10 +24,27 @@ func GetDegreesValue(degrees int) int {
 	return GetValue(degrees, 1700, 6500)
 }
 
+// GetDegreesPercentValue converts 0.0-1.0 fraction to 1700-6500 K, 0.0 being the warmest,
+// the fraction is clamped before conversion and NaN maps to the warmest value
+func GetDegreesPercentValue(percent float64) int {
+	return GetDegreesValue(int(math.Round(1700 + clampFraction(percent)*(6500-1700))))
+}
+
 func GetDurationValue(duration int) int {
 	return minInt(duration, 50)
 }
 
+// clampFraction limits fraction to 0.0-1.0, NaN is treated as 0.0
+func clampFraction(percent float64) float64 {
+	if math.IsNaN(percent) || percent < 0 {
+		return 0
+	}
+	if percent > 1 {
+		return 1
+	}
+	return percent
+}
+
 func GetValue(x, min, max int) int {
 	return maxInt(minInt(x, min), max)
 }
@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"math"
+	"testing"
+)
+
+func TestGetBrightnessPercentValue(t *testing.T) {
+	tests := []struct {
+		percent float64
+		want    int
+	}{
+		{0.0, 1},
+		{0.004, 1},
+		{0.5, 50},
+		{1.0, 100},
+		{-0.5, 1},
+		{1.5, 100},
+		{math.NaN(), 1},
+		{math.Inf(1), 100},
+		{math.Inf(-1), 1},
+		{1e300, 100},
+		{-1e300, 1},
+	}
+	for _, tt := range tests {
+		if got := GetBrightnessPercentValue(tt.percent); got != tt.want {
+			t.Errorf("GetBrightnessPercentValue(%v) = %d, want %d", tt.percent, got, tt.want)
+		}
+	}
+}
+
+func TestGetDegreesPercentValue(t *testing.T) {
+	tests := []struct {
+		percent float64
+		want    int
+	}{
+		{0.0, 1700},
+		{0.5, 4100},
+		{1.0, 6500},
+		{-1.0, 1700},
+		{2.0, 6500},
+		{math.NaN(), 1700},
+		{math.Inf(1), 6500},
+		{math.Inf(-1), 1700},
+		{1e300, 6500},
+		{-1e300, 1700},
+	}
+	for _, tt := range tests {
+		if got := GetDegreesPercentValue(tt.percent); got != tt.want {
+			t.Errorf("GetDegreesPercentValue(%v) = %d, want %d", tt.percent, got, tt.want)
+		}
+	}
+}
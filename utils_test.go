@@ -0,0 +1,123 @@
+package yeelight
+
+import "testing"
+
+func TestValidBrightness(t *testing.T) {
+	tests := []struct {
+		brightness int
+		want       bool
+	}{
+		{0, false},
+		{MinBrightness, true},
+		{MaxBrightness, true},
+		{101, false},
+	}
+	for _, tt := range tests {
+		if got := ValidBrightness(tt.brightness); got != tt.want {
+			t.Errorf("ValidBrightness(%d) = %t, want %t", tt.brightness, got, tt.want)
+		}
+	}
+}
+
+func TestBrightnessOutOfBoundsIsNotSent(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb()
+
+	for _, brightness := range []int{0, 101} {
+		if _, err := y.TurnOnWith(Normal, brightness, 0); err == nil {
+			t.Errorf("TurnOnWith brightness %d expected error", brightness)
+		}
+	}
+	if methods := f.methods(); len(methods) != 0 {
+		t.Errorf("expected nothing sent, got %v", methods)
+	}
+	for _, brightness := range []int{MinBrightness, MaxBrightness} {
+		if _, err := y.TurnOnWith(Normal, brightness, 0); err != nil {
+			t.Errorf("TurnOnWith brightness %d unexpected error %s", brightness, err)
+		}
+	}
+}
+
+func TestNearestCT(t *testing.T) {
+	tests := []struct {
+		k    int
+		want int
+	}{
+		{0, MinColorTemperature},
+		{1700, 1700},
+		{4049, 4000},
+		{4050, 4100},
+		{6500, 6500},
+		{9000, MaxColorTemperature},
+	}
+	for _, tt := range tests {
+		if got := NearestCT(tt.k); got != tt.want {
+			t.Errorf("NearestCT(%d) = %d, want %d", tt.k, got, tt.want)
+		}
+	}
+}
+
+func TestSetColorTemperatureSendsNearestCT(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb()
+
+	if _, err := y.SetColorTemperature(2740); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if got := paramsOf(f.last("set_ct_abx"))[0]; got != "2700" {
+		t.Errorf("expected 2700, got %s", got)
+	}
+}
+
+func TestKelvinMiredRoundTrip(t *testing.T) {
+	for _, k := range []int{MinColorTemperature, 2700, 4000, MaxColorTemperature} {
+		m := KelvinToMired(k)
+		// mired is coarse at high Kelvin, one mired step is ~40K at 6500K
+		if back := MiredToKelvin(m); back < k-50 || back > k+50 {
+			t.Errorf("%dK converted to %d mired and back to %dK", k, m, back)
+		}
+	}
+	if got := KelvinToMired(4000); got != 250 {
+		t.Errorf("expected 250 mired for 4000K, got %d", got)
+	}
+	if got := MiredToKelvin(250); got != 4000 {
+		t.Errorf("expected 4000K for 250 mired, got %d", got)
+	}
+}
+
+func TestKelvinMiredGuardNonPositive(t *testing.T) {
+	for _, v := range []int{0, -1} {
+		if got := KelvinToMired(v); got != 0 {
+			t.Errorf("KelvinToMired(%d) = %d, want 0", v, got)
+		}
+		if got := MiredToKelvin(v); got != 0 {
+			t.Errorf("MiredToKelvin(%d) = %d, want 0", v, got)
+		}
+	}
+}
+
+func TestSetColorTemperatureMired(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb()
+
+	if _, err := y.SetColorTemperatureMired(370); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if got := paramsOf(f.last("set_ct_abx"))[0]; got != "2700" {
+		t.Errorf("expected 2700K, got %s", got)
+	}
+	for _, m := range []int{0, -5, 100, 600} {
+		if _, err := y.SetColorTemperatureMired(m); err == nil {
+			t.Errorf("SetColorTemperatureMired(%d) expected error", m)
+		}
+	}
+	sent := 0
+	for _, method := range f.methods() {
+		if method == "set_ct_abx" {
+			sent++
+		}
+	}
+	if sent != 1 {
+		t.Errorf("expected rejected values not sent, got %d set_ct_abx", sent)
+	}
+}
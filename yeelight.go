@@ -2,19 +2,26 @@ package yeelight
 
 import (
 	"bufio"
-	"encoding/json"
 	"errors"
 	"fmt"
 	c "github.com/akominch/yeelight/color"
 	"github.com/akominch/yeelight/utils"
+	"golang.org/x/net/ipv4"
 	"image/color"
+	"io"
 	"log"
+	"math"
 	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
 const (
-	discoverMSG = "M-SEARCH * HTTP/1.1\r\n HOST:239.255.255.250:1982\r\n MAN:\"ssdp:discover\"\r\n ST:wifi_bulb\r\n"
+	//SSDP M-SEARCH request, HOST is filled by the target address
+	searchMSG = "M-SEARCH * HTTP/1.1\r\nHOST: %s\r\nMAN: \"ssdp:discover\"\r\nMX: 3\r\nST: wifi_bulb\r\n\r\n"
 
 	// timeout value for TCP and UDP commands
 	timeout = time.Second * 3
@@ -24,33 +31,79 @@ const (
 
 	//CR-LF delimiter
 	crlf = "\r\n"
+
+	// default TCP port of the bulb control server
+	defaultPort = 55443
+
+	// number of simultaneous TCP connections accepted by the bulb
+	defaultMaxConnections = 4
+
+	// default size of listener notifications buffer
+	defaultNotificationBuffer = 16
 )
 
+// discoverMSG is M-SEARCH request sent to the multicast group
+var discoverMSG = fmt.Sprintf(searchMSG, ssdpAddr)
+
 type EffectType string
 
 const (
 	Smooth EffectType = "smooth"
-	Sudden            = "sudden"
+	Sudden EffectType = "sudden"
 )
 
 type LightType int
 
 const (
 	Main    LightType = 0
-	Ambient           = 1
+	Ambient LightType = 1
 )
 
+// method returns command name for the light, background light commands have bg_ prefix
+func (t LightType) method(name string) string {
+	if t == Ambient {
+		return "bg_" + name
+	}
+	return name
+}
+
 type Mode int
 
 const (
 	Last      Mode = 0
-	Normal         = 1
-	RGB            = 2
-	HSV            = 3
-	ColorFlow      = 4
-	Moonlight      = 5
+	Normal    Mode = 1
+	RGB       Mode = 2
+	HSV       Mode = 3
+	ColorFlow Mode = 4
+	Moonlight Mode = 5
 )
 
+var modeNames = map[Mode]string{
+	Last:      "Last",
+	Normal:    "Normal",
+	RGB:       "RGB",
+	HSV:       "HSV",
+	ColorFlow: "ColorFlow",
+	Moonlight: "Moonlight",
+}
+
+func (m Mode) String() string {
+	if name, ok := modeNames[m]; ok {
+		return name
+	}
+	return fmt.Sprintf("Mode(%d)", int(m))
+}
+
+// ParseMode parses mode name as returned by Mode.String, case insensitive
+func ParseMode(s string) (Mode, error) {
+	for mode, name := range modeNames {
+		if strings.EqualFold(name, s) {
+			return mode, nil
+		}
+	}
+	return Last, fmt.Errorf("unknown mode %q", s)
+}
+
 type (
 	PropsResult struct {
 		ID     int
@@ -79,75 +132,352 @@ type BulbConfig struct {
 
 //Bulb represents device
 type Bulb struct {
-	ip     string
-	addr   string
-	effect EffectType
-	cmdId  int
+	ip    string
+	port  int
+	addr  string
+	cmdId int
+
+	// effectMu guards effect, which SetEffect may change while commands are sent
+	effectMu sync.Mutex
+	effect   EffectType
+
+	timeout   time.Duration
+	logger    *log.Logger
+	keepAlive bool
+
+	pingInterval time.Duration
+	pingStop     chan struct{}
+	pingOnce     sync.Once
+
+	// rateMu guards lastCmd, the time reserved for the latest command
+	rateLimit time.Duration
+	rateMu    sync.Mutex
+	lastCmd   time.Time
+
+	mu        sync.Mutex
+	client    client
+	music     bool
+	musicConn net.Conn
+
+	// music writes are buffered when musicBatch or musicFlushEvery is set
+	musicBatch      int
+	musicFlushEvery time.Duration
+	musicBuf        *bufio.Writer
+	musicPending    int
+	musicTimer      *time.Timer
+
+	recMu    sync.Mutex
+	recorder io.Writer
+
+	overflowPolicy OverflowPolicy
+	onDropped      func(*Notification)
+	onUnknownProp  func(string)
+
+	autoFallback  bool
+	fallbackOnce  sync.Once
+	stopFlowOnSet bool
+	stopFlowOnOff bool
+	autoPowerOn   bool
+	errorWhenOff  bool
+	strict        bool
+
+	powerMu sync.Mutex
+	power   bool
+	powerAt time.Time
+
+	idMu         sync.Mutex
+	queued       int32
+	onQueueDepth func(int)
+
+	lastMu     sync.Mutex
+	lastResult *CommandResult
+	lastErr    error
+
+	maxConnections int
+	conns          chan struct{}
+
+	notificationBuffer int
+
+	// liveProps caches props from notifications and GetState, see CurrentState
+	liveMu        sync.Mutex
+	liveProps     map[string]string
+	liveListeners int
+
+	// connState is ConnState, accessed atomically
+	connState int32
+
+	// metaMu guards ip, port, addr and params, Registry updates them when the bulb is found again
+	metaMu sync.RWMutex
+	// params holds discovery answer, nil for bulbs created by ip
+	params *YeelightParams
 }
 
 func New(config BulbConfig) *Bulb {
-	if config.Ip == "" {
+	var opts []Option
+	if config.Effect != "" {
+		opts = append(opts, WithEffect(config.Effect))
+	}
+
+	return NewWithOptions(config.Ip, opts...)
+}
+
+// NewWithOptions creates bulb with given ip configured by functional options
+func NewWithOptions(ip string, opts ...Option) *Bulb {
+	if ip == "" {
 		log.Fatalln("Please, add bulb ip to yeelight config")
 	}
 
 	y := &Bulb{
-		ip:    config.Ip,
-		addr:  fmt.Sprintf("%s:55443", config.Ip),
-		cmdId: 0,
+		ip:      ip,
+		port:    defaultPort,
+		effect:  Smooth,
+		cmdId:   0,
+		timeout: timeout,
+		logger:  log.New(os.Stderr, "yeelight: ", log.LstdFlags),
+
+		maxConnections:     defaultMaxConnections,
+		notificationBuffer: defaultNotificationBuffer,
+		autoPowerOn:        true,
 	}
 
-	if config.Effect != "" {
-		y.effect = config.Effect
-	} else {
-		y.effect = Smooth
+	for _, opt := range opts {
+		opt(y)
+	}
+	y.addr = fmt.Sprintf("%s:%d", y.ip, y.port)
+	y.conns = make(chan struct{}, y.maxConnections)
+	y.client = y.newClient()
+	if y.keepAlive && y.pingInterval > 0 {
+		y.pingStop = make(chan struct{})
+		go y.ping()
 	}
 
 	return y
 }
 
-//Discover discovers device in local network via ssdp
-func Discover() (*Bulb, error) {
-	var err error
+// DiscoverOptions configures SSDP discovery
+type DiscoverOptions struct {
+	// Timeout limits how long DiscoverAll waits for answers, 3 seconds when zero
+	Timeout time.Duration
+	// LoopbackDisabled disables multicast loopback, so the socket does not receive its own M-SEARCH
+	LoopbackDisabled bool
+	// TTL sets multicast TTL of M-SEARCH, system default is used when zero
+	TTL int
+	// Interface sends M-SEARCH through given interface, see DiscoverInterfaces
+	Interface *net.Interface
+	// SourcePort binds discovery socket to given UDP port, random port is used when zero.
+	// Concurrent discoveries need zero or distinct ports
+	SourcePort int
+	// SubnetFilter makes DiscoverAll drop answers of bulbs with ip outside the subnet, all are kept when nil
+	SubnetFilter *net.IPNet
+	// Retries sends M-SEARCH given number of times more, RetryGap apart, as multicast is often lost
+	Retries int
+	// RetryGap is interval between M-SEARCH retries, 300ms when zero
+	RetryGap time.Duration
+	// ArrivalOrder makes DiscoverAll return bulbs in order of answers instead of sorted by id
+	ArrivalOrder bool
+}
 
-	ssdp, _ := net.ResolveUDPAddr("udp4", ssdpAddr)
-	c, _ := net.ListenPacket("udp4", ":0")
-	socket := c.(*net.UDPConn)
-	socket.WriteToUDP([]byte(discoverMSG), ssdp)
-	socket.SetReadDeadline(time.Now().Add(timeout))
+// host returns ip of the bulb
+func (y *Bulb) host() string {
+	y.metaMu.RLock()
+	defer y.metaMu.RUnlock()
 
-	rsBuf := make([]byte, 1024)
-	size, _, err := socket.ReadFromUDP(rsBuf)
-	if err != nil {
-		return nil, errors.New("no devices found")
+	return y.ip
+}
+
+// address returns host:port of the bulb control server
+func (y *Bulb) address() string {
+	y.metaMu.RLock()
+	defer y.metaMu.RUnlock()
+
+	return y.addr
+}
+
+// discovery returns the latest discovery answer, nil for bulbs created by ip
+func (y *Bulb) discovery() *YeelightParams {
+	y.metaMu.RLock()
+	defer y.metaMu.RUnlock()
+
+	return y.params
+}
+
+// setDiscovery stores discovery answer and the address it advertises.
+// Open connections to the previous address are kept until they break
+func (y *Bulb) setDiscovery(p *YeelightParams) {
+	y.metaMu.Lock()
+	defer y.metaMu.Unlock()
+
+	if ip, port, ok := p.hostPort(); ok {
+		y.ip, y.port = ip, port
+		y.addr = fmt.Sprintf("%s:%d", ip, port)
 	}
-	rs := rsBuf[0:size]
-	addr := parseAddr(string(rs))
-	fmt.Printf("Device with ip %s found\n", addr)
+	y.params = p
+}
 
-	return New(BulbConfig{Ip: addr}), nil
+// Discovered reports whether the bulb was found by discovery and has its SSDP metadata
+func (y *Bulb) Discovered() bool {
+	return y.discovery() != nil
 }
 
-func (y *Bulb) Discover() (*YeelightParams, error) {
-	var err error
+// ID returns device id from discovery, empty for bulbs created by ip
+func (y *Bulb) ID() string {
+	p := y.discovery()
+	if p == nil {
+		return ""
+	}
+	return p.ID
+}
+
+// Name returns bulb name from discovery, empty for bulbs created by ip or without name
+func (y *Bulb) Name() string {
+	p := y.discovery()
+	if p == nil {
+		return ""
+	}
+	return p.Name
+}
+
+// FirmwareVersion returns firmware version from discovery, empty for bulbs created by ip
+func (y *Bulb) FirmwareVersion() string {
+	p := y.discovery()
+	if p == nil || p.FwVer == 0 {
+		return ""
+	}
+	return strconv.Itoa(p.FwVer)
+}
+
+// FirmwareAtLeast reports whether firmware version from discovery is at least v. Bulbs with
+// unknown firmware, e.g. created by ip, are assumed to run recent firmware
+func (y *Bulb) FirmwareAtLeast(v int) bool {
+	p := y.discovery()
+	if p == nil || p.FwVer == 0 {
+		return true
+	}
+	return p.FwVer >= v
+}
+
+// Firmware versions which introduced commands or their extended forms
+const (
+	// adjustMinFirmware is the first firmware version AdjustBrightness sends adjust_bright to,
+	// older bulbs list the method but do not handle it, so their brightness is read and set instead
+	adjustMinFirmware = 26
+	// powerBrightMinFirmware is the first firmware version TurnOnWith sends brightness in set_power to
+	powerBrightMinFirmware = 26
+)
+
+// ErrUnsupportedMethod is returned before sending command which is missing in the support list of the bulb
+var ErrUnsupportedMethod = errors.New("method not supported by the bulb")
+
+// Supports reports whether the bulb supports method according to its discovery answer.
+// Bulbs created by ip have no support list, for them all methods are reported as supported
+func (y *Bulb) Supports(method string) bool {
+	p := y.discovery()
+	if p == nil || len(p.Support) == 0 {
+		return true
+	}
+	for _, m := range p.Support {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSupport returns ErrUnsupportedMethod if the bulb is known not to support method
+func (y *Bulb) checkSupport(method string) error {
+	if !y.Supports(method) {
+		return fmt.Errorf("cannot call %s. %w", method, ErrUnsupportedMethod)
+	}
+	return nil
+}
+
+// SetEffect sets effect used for transitions, only Smooth and Sudden are accepted
+func (y *Bulb) SetEffect(effect EffectType) error {
+	if effect != Smooth && effect != Sudden {
+		return fmt.Errorf("unknown effect %q, use Smooth or Sudden", effect)
+	}
+	y.effectMu.Lock()
+	defer y.effectMu.Unlock()
+
+	y.effect = effect
+	return nil
+}
 
-	addr := fmt.Sprintf("%s:1982", y.ip)
-	msg := fmt.Sprintf("M-SEARCH * HTTP/1.1\r\n HOST:%s\r\n MAN:\"ssdp:discover\"\r\n ST:wifi_bulb\r\n", addr)
+// currentEffect returns effect used for transitions
+func (y *Bulb) currentEffect() EffectType {
+	y.effectMu.Lock()
+	defer y.effectMu.Unlock()
 
-	ssdp, _ := net.ResolveUDPAddr("udp4", ssdpAddr)
-	c, _ := net.ListenPacket("udp4", ":0")
+	return y.effect
+}
 
-	socket := c.(*net.UDPConn)
-	socket.WriteToUDP([]byte(msg), ssdp)
-	socket.SetReadDeadline(time.Now().Add(timeout))
+//Discover discovers device in local network via ssdp
+func Discover() (*Bulb, error) {
+	return DiscoverWithOptions(DiscoverOptions{})
+}
 
-	rsBuf := make([]byte, 1024)
-	size, _, err := socket.ReadFromUDP(rsBuf)
+// DiscoverWithOptions discovers device in local network via ssdp using given options
+func DiscoverWithOptions(opts DiscoverOptions) (*Bulb, error) {
+	s, err := openSearch(opts, discoverMSG)
 	if err != nil {
-		return nil, errors.New("no devices found")
+		return nil, err
 	}
-	rs := rsBuf[0:size]
+	defer s.close()
 
-	params := parseAnswer(string(rs))
+	//invalid answers are skipped, the next one may still come before the deadline
+	deadline := time.Now().Add(timeout)
+	for {
+		params, err := s.next(deadline)
+		if err != nil {
+			return nil, err
+		}
+		if params == nil {
+			return nil, ErrNoDevicesFound
+		}
+		if params.valid() {
+			return newDiscoveredBulb(params), nil
+		}
+	}
+}
+
+// applyMulticastOptions sets multicast loopback and TTL on discovery socket
+func applyMulticastOptions(socket *net.UDPConn, opts DiscoverOptions) error {
+	p := ipv4.NewPacketConn(socket)
+	if opts.LoopbackDisabled {
+		if err := p.SetMulticastLoopback(false); err != nil {
+			return fmt.Errorf("cannot disable multicast loopback. %s", err)
+		}
+	}
+	if opts.TTL > 0 {
+		if err := p.SetMulticastTTL(opts.TTL); err != nil {
+			return fmt.Errorf("cannot set multicast TTL. %s", err)
+		}
+	}
+	if opts.Interface != nil {
+		if err := p.SetMulticastInterface(opts.Interface); err != nil {
+			return fmt.Errorf("cannot set multicast interface %s. %s", opts.Interface.Name, err)
+		}
+	}
+	return nil
+}
+
+func (y *Bulb) Discover() (*YeelightParams, error) {
+	addr := fmt.Sprintf("%s:1982", y.host())
+	msg := fmt.Sprintf(searchMSG, addr)
+
+	s, err := openSearch(DiscoverOptions{}, msg)
+	if err != nil {
+		return nil, err
+	}
+	defer s.close()
+
+	params, err := s.next(time.Now().Add(timeout))
+	if err != nil {
+		return nil, err
+	}
+	if params == nil {
+		return nil, ErrNoDevicesFound
+	}
 	return params, nil
 }
 
@@ -156,28 +486,118 @@ func (y *Bulb) TurnOn() (*CommandResult, error) {
 }
 
 func (y *Bulb) TurnOnWithParams(mode Mode, duration int) (*CommandResult, error) {
-	return y.ExecuteCommand("set_power", "on", y.effect, duration, mode)
+	return y.ExecuteCommand("set_power", "on", y.currentEffect(), duration, mode)
+}
+
+// TurnOnWith turns the bulb on in given mode and brightness (1-100). Firmware from powerBrightMinFirmware
+// gets single extended set_power, older bulbs and bulbs rejecting its params get set_power followed by set_bright
+func (y *Bulb) TurnOnWith(mode Mode, brightness int, duration int) (*CommandResult, error) {
+	if !ValidBrightness(brightness) {
+		return nil, brightnessError(brightness)
+	}
+	duration = utils.GetDurationValue(duration)
+	if y.FirmwareAtLeast(powerBrightMinFirmware) {
+		res, err := y.ExecuteCommand("set_power", "on", y.currentEffect(), duration, mode, brightness)
+		if !isInvalidParams(err) && !isMethodNotSupported(err) {
+			return res, err
+		}
+	}
+
+	if _, err := y.TurnOnWithParams(mode, duration); err != nil {
+		return nil, err
+	}
+	return y.ExecuteCommand("set_bright", brightness, y.currentEffect(), duration)
 }
 
 func (y *Bulb) TurnOff() (*CommandResult, error) {
+	if err := y.prepareOff(); err != nil {
+		return nil, err
+	}
 	return y.ExecuteCommand("set_power", "off")
 }
 
+// prepareOff is called before turning the bulb off
+func (y *Bulb) prepareOff() error {
+	if y.stopFlowOnOff {
+		if _, err := y.StopFlow(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TurnOffWithDuration fades the light out over duration ms. Duration applies only
+// with Smooth effect, Sudden effect turns the bulb off at once
+func (y *Bulb) TurnOffWithDuration(duration int) (*CommandResult, error) {
+	if err := y.prepareOff(); err != nil {
+		return nil, err
+	}
+	if y.currentEffect() != Smooth {
+		return y.ExecuteCommand("set_power", "off", Sudden, 0)
+	}
+	return y.ExecuteCommand("set_power", "off", Smooth, utils.GetDurationValue(duration))
+}
+
+// EnsureOn turns the bulb on if it is off. Errors are wrapped, so commands relying
+// on it fail fast with the cause
 func (y *Bulb) EnsureOn() error {
 	isOn, err := y.IsOn()
 	if err != nil {
-		return err
+		return fmt.Errorf("cannot read power state. %w", err)
 	}
 	if !isOn {
 		_, err := y.TurnOn()
 		if err != nil {
-			return err
+			return fmt.Errorf("cannot turn bulb on. %w", err)
 		}
 	}
 
 	return nil
 }
 
+// ErrBulbOff is returned by set_* commands for bulb known to be off
+// when auto power on is disabled and WithErrorWhenOff is set
+var ErrBulbOff = errors.New("bulb is off")
+
+// powerCacheTTL is how long power state read from the bulb is considered recent
+const powerCacheTTL = 10 * time.Second
+
+// setKnownPower remembers power state reported by or set on the bulb
+func (y *Bulb) setKnownPower(on bool) {
+	y.powerMu.Lock()
+	defer y.powerMu.Unlock()
+
+	y.power = on
+	y.powerAt = time.Now()
+}
+
+// knownPower returns recently known power state
+func (y *Bulb) knownPower() (on bool, known bool) {
+	y.powerMu.Lock()
+	defer y.powerMu.Unlock()
+
+	return y.power, !y.powerAt.IsZero() && time.Since(y.powerAt) < powerCacheTTL
+}
+
+// prepareSet is called before set_* commands of the main light
+func (y *Bulb) prepareSet() error {
+	if y.autoPowerOn {
+		if err := y.EnsureOn(); err != nil {
+			return err
+		}
+	} else if y.errorWhenOff {
+		if on, known := y.knownPower(); known && !on {
+			return ErrBulbOff
+		}
+	}
+	if y.stopFlowOnSet {
+		if _, err := y.StopFlow(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (y *Bulb) IsOn() (bool, error) {
 	res, err := y.GetProps([]string{"power"})
 	if err != nil {
@@ -188,39 +608,207 @@ func (y *Bulb) IsOn() (bool, error) {
 	return power == "on", nil
 }
 
+// SetBrightness sets brightness on the bulb 1-100 scale, values out of range are clamped
 func (y *Bulb) SetBrightness(brightness int) (*CommandResult, error) {
-	if err := y.EnsureOn(); err != nil {
+	if err := y.prepareSet(); err != nil {
 		return nil, err
 	}
-	return y.ExecuteCommand("set_bright", utils.GetBrightnessValue(brightness), y.effect)
+	return y.ExecuteCommand("set_bright", utils.GetBrightnessValue(brightness), y.currentEffect())
+}
+
+// SetBrightnessForLightType sets brightness of main or ambient (background) light
+func (y *Bulb) SetBrightnessForLightType(lightType LightType, brightness int, duration int) (*CommandResult, error) {
+	if lightType == Main {
+		if err := y.prepareSet(); err != nil {
+			return nil, err
+		}
+	}
+	return y.ExecuteCommand(lightType.method("set_bright"), utils.GetBrightnessValue(brightness), y.currentEffect(), utils.GetDurationValue(duration))
+}
+
+// SetBrightnessPercent sets brightness given as 0.0-1.0 fraction. Bulb minimum is 1,
+// so 0.0 maps to the dimmest light instead of turning the bulb off. NaN is rejected
+func (y *Bulb) SetBrightnessPercent(percent float64) (*CommandResult, error) {
+	if math.IsNaN(percent) {
+		return nil, fmt.Errorf("the brightness fraction to set (0.0-1.0), got %v", percent)
+	}
+	return y.SetBrightness(utils.GetBrightnessPercentValue(percent))
 }
 
+// ErrInvalidColor is returned for black color, which the bulb rejects
+var ErrInvalidColor = errors.New("black color cannot be set, use TurnOff instead")
+
+// SetRGB sets color of the bulb. Black is rejected with ErrInvalidColor
 func (y *Bulb) SetRGB(rgba color.RGBA) (*CommandResult, error) {
 	value := c.RGBToYeelight(rgba)
-	if err := y.EnsureOn(); err != nil {
+	if value == 0 {
+		return nil, ErrInvalidColor
+	}
+	if err := y.checkColorMode(RGB); err != nil {
+		return nil, err
+	}
+	if err := y.prepareSet(); err != nil {
+		return nil, err
+	}
+	return y.ExecuteCommand("set_rgb", value, y.currentEffect())
+}
+
+// SetRGBInt sets color given as packed 24-bit rgb value (1-16777215)
+func (y *Bulb) SetRGBInt(value int, duration int) (*CommandResult, error) {
+	if value < 1 || value > 0xffffff {
+		return nil, fmt.Errorf("the rgb value to set (1-16777215), got %d", value)
+	}
+	if err := y.checkColorMode(RGB); err != nil {
+		return nil, err
+	}
+	if err := y.prepareSet(); err != nil {
 		return nil, err
 	}
-	return y.ExecuteCommand("set_rgb", value, y.effect)
+	return y.ExecuteCommand("set_rgb", value, y.currentEffect(), utils.GetDurationValue(duration))
 }
 
+// SetColorHex sets color given as "#RRGGBB" or "RRGGBB" over duration ms
+func (y *Bulb) SetColorHex(hex string, duration int) (*CommandResult, error) {
+	rgba, err := c.ParseHex(hex)
+	if err != nil {
+		return nil, err
+	}
+	value := c.RGBToYeelight(rgba)
+	if value == 0 {
+		return nil, ErrInvalidColor
+	}
+	return y.SetRGBInt(value, duration)
+}
+
+// SetColorXY sets color given as CIE 1931 xy coordinates and brightness (1-100) in a single command
+func (y *Bulb) SetColorXY(x, yy float64, brightness int) (*CommandResult, error) {
+	if !ValidBrightness(brightness) {
+		return nil, brightnessError(brightness)
+	}
+	value := c.RGBToYeelight(c.XYToRGB(x, yy))
+	if value == 0 {
+		return nil, ErrInvalidColor
+	}
+	if err := y.checkColorMode(RGB); err != nil {
+		return nil, err
+	}
+	return y.SetScene("color", value, brightness)
+}
+
+// SetHSV sets color given as hue (0-359) and saturation (0-100). With auto fallback enabled,
+// bulbs without set_hsv get the color converted to rgb, right away when set_hsv is known to be
+// unsupported and after the bulb rejected it otherwise. Without fallback set_hsv support is required
 func (y *Bulb) SetHSV(hue int, saturation int) (*CommandResult, error) {
-	if err := y.EnsureOn(); err != nil {
+	modes := []Mode{HSV}
+	if y.autoFallback {
+		modes = append(modes, RGB)
+	}
+	if err := y.checkColorMode(modes...); err != nil {
+		return nil, err
+	}
+	if err := y.prepareSet(); err != nil {
+		return nil, err
+	}
+	if y.autoFallback && y.checkColorMode(HSV) != nil {
+		return y.setHSVAsRGB(hue, saturation)
+	}
+	res, err := y.ExecuteCommand("set_hsv", hue, saturation, y.currentEffect())
+	if err != nil && y.autoFallback && isMethodNotSupported(err) {
+		return y.setHSVAsRGB(hue, saturation)
+	}
+	return res, err
+}
+
+// setHSVAsRGB sends hsv color converted to rgb, the first fallback is logged
+func (y *Bulb) setHSVAsRGB(hue int, saturation int) (*CommandResult, error) {
+	y.fallbackOnce.Do(func() {
+		y.logger.Println("set_hsv is not supported by the bulb, falling back to set_rgb")
+	})
+	return y.ExecuteCommand("set_rgb", c.RGBToYeelight(c.HSVToRGB(hue, saturation)), y.currentEffect())
+}
+
+// SetColorTemperature sets white color temperature in Kelvin, the value is adjusted with NearestCT
+func (y *Bulb) SetColorTemperature(degrees int) (*CommandResult, error) {
+	if err := y.prepareSet(); err != nil {
 		return nil, err
 	}
-	return y.ExecuteCommand("set_rgb", hue, saturation, y.effect)
+	return y.ExecuteCommand("set_ct_abx", NearestCT(degrees), y.currentEffect())
+}
+
+// SetColorTemperatureMired sets color temperature given in mired, as used by HomeKit and Matter.
+// The value has to be within 154-588 mired (6500-1700 K)
+func (y *Bulb) SetColorTemperatureMired(m int) (*CommandResult, error) {
+	if m <= 0 {
+		return nil, fmt.Errorf("the mired value to set must be positive, got %d", m)
+	}
+	k := MiredToKelvin(m)
+	if k < MinColorTemperature || k > MaxColorTemperature {
+		return nil, fmt.Errorf("the mired value to set (%d-%d), got %d", KelvinToMired(MaxColorTemperature), KelvinToMired(MinColorTemperature), m)
+	}
+	return y.SetColorTemperature(k)
+}
+
+// SetColorTemperaturePercent sets color temperature given as 0.0 (warmest) - 1.0 (coolest) fraction,
+// NaN is rejected
+func (y *Bulb) SetColorTemperaturePercent(percent float64) (*CommandResult, error) {
+	if math.IsNaN(percent) {
+		return nil, fmt.Errorf("the color temperature fraction to set (0.0-1.0), got %v", percent)
+	}
+	return y.SetColorTemperature(utils.GetDegreesPercentValue(percent))
 }
 
 func (y *Bulb) SetBrightnessWithDuration(brightness int, duration int) (*CommandResult, error) {
-	if !checkBrightnessValue(brightness) {
-		log.Fatalln("The brightness value to set (1-100)")
+	if !ValidBrightness(brightness) {
+		return nil, brightnessError(brightness)
 	}
-	if err := y.EnsureOn(); err != nil {
+	if err := y.prepareSet(); err != nil {
+		return nil, err
+	}
+	return y.ExecuteCommand("set_bright", brightness, y.currentEffect(), duration)
+}
+
+// SetBrightnessSmooth dims or brightens the bulb to target over durationMs milliseconds
+// using the bulb native transition. Smooth effect is always used, regardless of configured effect
+func (y *Bulb) SetBrightnessSmooth(target int, durationMs int) (*CommandResult, error) {
+	if !ValidBrightness(target) {
+		return nil, brightnessError(target)
+	}
+	if err := y.prepareSet(); err != nil {
 		return nil, err
 	}
-	return y.ExecuteCommand("set_bright", brightness, y.effect, duration)
+	return y.ExecuteCommand("set_bright", target, Smooth, utils.GetDurationValue(durationMs))
+}
+
+// AdjustBrightness changes brightness by percentage (-100-100) of the current value over duration.
+// On firmware older than adjustMinFirmware or without adjust_bright, brightness is read and set
+// with set_bright. The same fallback is used when the bulb rejects adjust_bright as unsupported
+func (y *Bulb) AdjustBrightness(percentage int, duration int) (*CommandResult, error) {
+	if percentage < -100 || percentage > 100 {
+		return nil, fmt.Errorf("the percentage value to set (-100-100), got %d", percentage)
+	}
+	if y.Supports("adjust_bright") && y.FirmwareAtLeast(adjustMinFirmware) {
+		res, err := y.ExecuteCommand("adjust_bright", percentage, utils.GetDurationValue(duration))
+		if !isMethodNotSupported(err) {
+			return res, err
+		}
+	}
+
+	res, err := y.GetProps([]string{"bright"})
+	if err != nil {
+		return nil, err
+	}
+	bright, err := parseIntProp(res.Result["bright"])
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse brightness %s", err)
+	}
+	bright = utils.GetBrightnessValue(bright + bright*percentage/100)
+	return y.ExecuteCommand("set_bright", bright, y.currentEffect(), utils.GetDurationValue(duration))
 }
 
 func (y *Bulb) StartFlow(flow *Flow) (*CommandResult, error) {
+	if err := y.checkSupport("start_cf"); err != nil {
+		return nil, err
+	}
 	if err := y.EnsureOn(); err != nil {
 		return nil, err
 	}
@@ -228,11 +816,92 @@ func (y *Bulb) StartFlow(flow *Flow) (*CommandResult, error) {
 	return y.ExecuteCommand("start_cf", params)
 }
 
+// StartFlowRaw starts flow given as start_cf params, expr is comma separated list of
+// duration, mode, value and brightness tuples and count is number of state changes, 0 for endless
+func (y *Bulb) StartFlowRaw(count int, action FlowAction, expr string) (*CommandResult, error) {
+	if err := y.checkSupport("start_cf"); err != nil {
+		return nil, err
+	}
+	if err := y.EnsureOn(); err != nil {
+		return nil, err
+	}
+	return y.ExecuteCommand("start_cf", count, action, expr)
+}
+
+// UpdateFlow replaces running flow by sending stop_cf and start_cf right after each other.
+// The bulb may briefly show the state after stop_cf between them
+func (y *Bulb) UpdateFlow(flow *Flow) error {
+	if err := y.checkSupport("start_cf"); err != nil {
+		return err
+	}
+	params := flow.AsStartParams()
+	if _, err := y.StopFlow(); err != nil {
+		return err
+	}
+	_, err := y.ExecuteCommand("start_cf", params)
+	return err
+}
+
 func (y *Bulb) StopFlow() (*CommandResult, error) {
-	return y.ExecuteCommand("stop_cf", "")
+	return y.ExecuteCommand("stop_cf")
 }
 
+// StopFlowIfRunning stops color flow only when the bulb reports it is flowing,
+// it returns whether a flow was stopped
+func (y *Bulb) StopFlowIfRunning() (bool, error) {
+	res, err := y.GetProps([]string{"flowing"})
+	if err != nil {
+		return false, err
+	}
+	if res.Result["flowing"] != "1" {
+		return false, nil
+	}
+	if _, err := y.StopFlow(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// BgStartFlow starts flow on background light of dual-light models
+func (y *Bulb) BgStartFlow(flow *Flow) (*CommandResult, error) {
+	if err := y.checkSupport("bg_start_cf"); err != nil {
+		return nil, err
+	}
+	return y.ExecuteCommand("bg_start_cf", flow.AsStartParams())
+}
+
+// BgStopFlow stops flow running on background light
+func (y *Bulb) BgStopFlow() (*CommandResult, error) {
+	if err := y.checkSupport("bg_stop_cf"); err != nil {
+		return nil, err
+	}
+	return y.ExecuteCommand("bg_stop_cf")
+}
+
+// SetAmbientLight turns ambient (background) light of ceiling lamps on or off.
+// Bulbs known to have no ambient light get ErrUnsupportedMethod
+func (y *Bulb) SetAmbientLight(on bool) (*CommandResult, error) {
+	switch model := y.Model(); model.Type {
+	case ModelMono, ModelColor, ModelStripe, ModelCTBulb, ModelDeskLamp:
+		return nil, fmt.Errorf("%s has no ambient light. %w", model, ErrUnsupportedMethod)
+	}
+	if err := y.checkSupport("bg_set_power"); err != nil {
+		return nil, err
+	}
+	power := "off"
+	if on {
+		power = "on"
+	}
+	return y.ExecuteCommand("bg_set_power", power)
+}
+
+// GetProps reads given props, unknown prop names are reported to logger as the bulb returns them empty
 func (y *Bulb) GetProps(props []string) (*PropsResult, error) {
+	for _, prop := range props {
+		if !knownProps[prop] {
+			y.unknownProp(prop)
+		}
+	}
 	res, err := y.ExecuteCommand("get_prop", props)
 	if err != nil {
 		return nil, err
@@ -240,56 +909,28 @@ func (y *Bulb) GetProps(props []string) (*PropsResult, error) {
 
 	propsMap := make(map[string]string)
 
-	for i, val := range res.Result {
-		key := props[i]
-		propsMap[key] = fmt.Sprintf("%v", val)
+	for i, val := range res.ResultStrings() {
+		if i < len(props) {
+			propsMap[props[i]] = val
+		}
+	}
+	if power, ok := propsMap["power"]; ok {
+		y.setKnownPower(power == "on")
 	}
 
 	return &PropsResult{ID: res.ID, Error: res.Error, Result: propsMap}, nil
 }
 
+// unknownProp reports requested prop missing in knownProps
+func (y *Bulb) unknownProp(prop string) {
+	if y.onUnknownProp != nil {
+		y.onUnknownProp(prop)
+		return
+	}
+	y.logger.Printf("unknown prop %q requested, bulb will return it empty", prop)
+}
+
 func (y *Bulb) SetName(name string) (*CommandResult, error) {
 	return y.ExecuteCommand("set_name", name)
 }
 
-// Listen connects to device and listens for NOTIFICATION events
-func (y *Bulb) Listen() (<-chan *Notification, chan<- struct{}, error) {
-	var err error
-	notifCh := make(chan *Notification)
-	done := make(chan struct{}, 1)
-
-	conn, err := net.DialTimeout("tcp", y.addr, time.Second*3)
-	if err != nil {
-		return nil, nil, fmt.Errorf("cannot connect to %s. %s", y.addr, err)
-	}
-
-	fmt.Println("Connection established")
-	go func(c net.Conn) {
-		//make sure connection is closed when method returns
-		defer closeConnection(conn)
-
-		connReader := bufio.NewReader(c)
-		for {
-			select {
-			case <-done:
-				return
-			default:
-				data, err := connReader.ReadString('\n')
-				if nil == err {
-					var rs Notification
-					fmt.Println(data)
-					json.Unmarshal([]byte(data), &rs)
-					select {
-					case notifCh <- &rs:
-					default:
-						fmt.Println("Channel is full")
-					}
-				}
-			}
-
-		}
-
-	}(conn)
-
-	return notifCh, done, nil
-}
@@ -0,0 +1,707 @@
+package yeelight
+
+import (
+	"bytes"
+	"errors"
+	"image/color"
+	"log"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRefusedConnectionReportsLANControlDisabled(t *testing.T) {
+	y := NewWithOptions("127.0.0.1", WithPort(refusedPort(t)), WithTimeout(time.Second), WithLogger(quietLogger))
+
+	_, err := y.TurnOn()
+	if !errors.Is(err, ErrLANControlDisabled) {
+		t.Fatalf("expected ErrLANControlDisabled, got %v", err)
+	}
+}
+
+func TestReachableBulbIsNotReportedAsLANControlDisabled(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb()
+
+	if _, err := y.TurnOn(); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if methods := f.methods(); len(methods) != 1 || methods[0] != "set_power" {
+		t.Fatalf("expected set_power, got %v", methods)
+	}
+}
+
+func TestSetBrightnessPercent(t *testing.T) {
+	tests := []struct {
+		percent float64
+		want    string
+	}{
+		{0.0, "1"},
+		{0.5, "50"},
+		{1.0, "100"},
+		{2.0, "100"},
+	}
+	for _, tt := range tests {
+		f := newFakeBulb(t)
+		y := f.newBulb()
+
+		if _, err := y.SetBrightnessPercent(tt.percent); err != nil {
+			t.Fatalf("unexpected error %s", err)
+		}
+		if got := paramsOf(f.last("set_bright"))[0]; got != tt.want {
+			t.Errorf("SetBrightnessPercent(%v) sent brightness %s, want %s", tt.percent, got, tt.want)
+		}
+	}
+}
+
+func TestSetBrightnessPercentRejectsNaN(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb()
+
+	if _, err := y.SetBrightnessPercent(math.NaN()); err == nil {
+		t.Error("expected NaN to be rejected")
+	}
+	if methods := f.methods(); len(methods) != 0 {
+		t.Errorf("expected nothing sent, got %v", methods)
+	}
+}
+
+func TestSetRGBIntBoundaries(t *testing.T) {
+	for _, value := range []int{1, 0xffffff} {
+		f := newFakeBulb(t)
+		y := f.newBulb()
+
+		if _, err := y.SetRGBInt(value, 100); err != nil {
+			t.Fatalf("SetRGBInt(%d) unexpected error %s", value, err)
+		}
+		if got := paramsOf(f.last("set_rgb"))[0]; got != strconv.Itoa(value) {
+			t.Errorf("SetRGBInt(%d) sent %s", value, got)
+		}
+	}
+}
+
+func TestSetRGBIntRejectsOutOfRange(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb()
+
+	for _, value := range []int{0, -1, 0x1000000} {
+		if _, err := y.SetRGBInt(value, 100); err == nil {
+			t.Errorf("SetRGBInt(%d) expected error", value)
+		}
+	}
+	if methods := f.methods(); len(methods) != 0 {
+		t.Errorf("expected nothing sent, got %v", methods)
+	}
+}
+
+func TestSetBrightnessFailsWhenPowerCannotBeRead(t *testing.T) {
+	f := newFakeBulb(t)
+	f.reply(func(cmd Command) []string {
+		if cmd.Method == "get_prop" {
+			return []string{errorLine(cmd.ID, -1, "general error")}
+		}
+		return nil
+	})
+	y := f.newBulb()
+
+	_, err := y.SetBrightness(50)
+	var e *Error
+	if !errors.As(err, &e) || e.Message != "general error" {
+		t.Fatalf("expected bulb error from get_prop, got %v", err)
+	}
+	for _, method := range f.methods() {
+		if method == "set_bright" {
+			t.Fatal("set_bright was sent although power could not be read")
+		}
+	}
+}
+
+func TestSetBrightnessFailsWhenBulbIsUnreachable(t *testing.T) {
+	y := NewWithOptions("127.0.0.1", WithPort(refusedPort(t)), WithTimeout(time.Second), WithLogger(quietLogger))
+
+	if _, err := y.SetBrightness(50); !errors.Is(err, ErrLANControlDisabled) {
+		t.Fatalf("expected connection error, got %v", err)
+	}
+}
+
+func TestEnsureOnTurnsBulbOn(t *testing.T) {
+	f := newFakeBulb(t)
+	f.setProps(map[string]string{"power": "off"})
+	y := f.newBulb()
+
+	if err := y.EnsureOn(); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if got := paramsOf(f.last("set_power"))[0]; got != "on" {
+		t.Errorf("expected set_power on, got %s", got)
+	}
+}
+
+func TestSetRGBRejectsBlack(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb()
+
+	if _, err := y.SetRGB(color.RGBA{0, 0, 0, 255}); err != ErrInvalidColor {
+		t.Fatalf("expected ErrInvalidColor, got %v", err)
+	}
+	if methods := f.methods(); len(methods) != 0 {
+		t.Errorf("expected nothing sent, got %v", methods)
+	}
+}
+
+func TestSetEffect(t *testing.T) {
+	y := NewWithOptions("127.0.0.1")
+
+	if err := y.SetEffect("bouncy"); err == nil {
+		t.Error("expected unknown effect to be rejected")
+	}
+	if y.effect != Smooth {
+		t.Errorf("rejected effect changed effect to %s", y.effect)
+	}
+	if err := y.SetEffect(Sudden); err != nil || y.effect != Sudden {
+		t.Errorf("expected Sudden to be set, got %s %v", y.effect, err)
+	}
+}
+
+func TestSetEffectWhileCommandsAreSent(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			if _, err := y.SetBrightness(50); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		effect := Smooth
+		if i%2 == 0 {
+			effect = Sudden
+		}
+		if err := y.SetEffect(effect); err != nil {
+			t.Fatalf("unexpected error %s", err)
+		}
+	}
+	<-done
+}
+
+func TestEffectConstantsAreTyped(t *testing.T) {
+	for _, effect := range []interface{}{Smooth, Sudden} {
+		if _, ok := effect.(EffectType); !ok {
+			t.Errorf("%v is %T, not EffectType", effect, effect)
+		}
+	}
+}
+
+func TestSetColorTemperaturePercent(t *testing.T) {
+	tests := []struct {
+		percent float64
+		want    string
+	}{
+		{0.0, "1700"},
+		{0.5, "4100"},
+		{1.0, "6500"},
+		{1.5, "6500"},
+	}
+	for _, tt := range tests {
+		f := newFakeBulb(t)
+		y := f.newBulb()
+
+		if _, err := y.SetColorTemperaturePercent(tt.percent); err != nil {
+			t.Fatalf("unexpected error %s", err)
+		}
+		if got := paramsOf(f.last("set_ct_abx"))[0]; got != tt.want {
+			t.Errorf("SetColorTemperaturePercent(%v) sent %s, want %s", tt.percent, got, tt.want)
+		}
+	}
+}
+
+// rejectHSV makes fake bulb reject set_hsv as unsupported
+func rejectHSV(cmd Command) []string {
+	if cmd.Method == "set_hsv" {
+		return []string{errorLine(cmd.ID, -1, "method not supported")}
+	}
+	return nil
+}
+
+func TestSetColorTemperaturePercentRejectsNaN(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb()
+
+	if _, err := y.SetColorTemperaturePercent(math.NaN()); err == nil {
+		t.Error("expected NaN to be rejected")
+	}
+	if methods := f.methods(); len(methods) != 0 {
+		t.Errorf("expected nothing sent, got %v", methods)
+	}
+}
+
+func TestSetHSVFallsBackToRGB(t *testing.T) {
+	f := newFakeBulb(t)
+	f.reply(rejectHSV)
+	var logs bytes.Buffer
+	y := f.newBulb(WithAutoFallback(true), WithLogger(log.New(&logs, "", 0)))
+
+	for i := 0; i < 2; i++ {
+		if _, err := y.SetHSV(0, 100); err != nil {
+			t.Fatalf("unexpected error %s", err)
+		}
+	}
+	if got := paramsOf(f.last("set_rgb"))[0]; got != "16711680" {
+		t.Errorf("expected red as rgb, got %s", got)
+	}
+	if count := strings.Count(logs.String(), "falling back"); count != 1 {
+		t.Errorf("expected single warning, got %d", count)
+	}
+}
+
+func TestSetHSVWithoutFallbackReturnsError(t *testing.T) {
+	f := newFakeBulb(t)
+	f.reply(rejectHSV)
+	y := f.newBulb()
+
+	if _, err := y.SetHSV(0, 100); !isMethodNotSupported(err) {
+		t.Fatalf("expected method not supported error, got %v", err)
+	}
+	for _, method := range f.methods() {
+		if method == "set_rgb" {
+			t.Fatal("set_rgb was sent without auto fallback")
+		}
+	}
+}
+
+func TestSetHSVRequiresSupportWithoutFallback(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.discoveredBulb(YeelightParams{ID: "0x1", Model: "color", Support: []string{"get_prop", "set_rgb"}})
+
+	if _, err := y.SetHSV(0, 100); !errors.Is(err, ErrUnsupportedMethod) {
+		t.Fatalf("expected ErrUnsupportedMethod, got %v", err)
+	}
+	if methods := f.methods(); len(methods) != 0 {
+		t.Errorf("expected nothing sent, got %v", methods)
+	}
+}
+
+func TestSetHSVFallsBackToRGBWhenKnownUnsupported(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.discoveredBulb(YeelightParams{ID: "0x1", Model: "color", Support: []string{"get_prop", "set_rgb"}}, WithAutoFallback(true))
+
+	if _, err := y.SetHSV(0, 100); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	for _, method := range f.methods() {
+		if method == "set_hsv" {
+			t.Fatal("set_hsv was sent although it is not supported")
+		}
+	}
+	if got := paramsOf(f.last("set_rgb"))[0]; got != "16711680" {
+		t.Errorf("expected red as rgb, got %s", got)
+	}
+}
+
+func TestDiscoveredAndID(t *testing.T) {
+	manual := NewWithOptions("192.168.1.10")
+	if manual.Discovered() || manual.ID() != "" {
+		t.Errorf("expected manual bulb without metadata, got %t %q", manual.Discovered(), manual.ID())
+	}
+
+	found := newDiscoveredBulb(&YeelightParams{ID: "0x1", Location: "yeelight://192.168.1.10:55443"})
+	if !found.Discovered() || found.ID() != "0x1" {
+		t.Errorf("expected discovered bulb 0x1, got %t %q", found.Discovered(), found.ID())
+	}
+	if found.address() != "192.168.1.10:55443" {
+		t.Errorf("expected address from location, got %s", found.address())
+	}
+}
+
+func TestModeStringAndParse(t *testing.T) {
+	for _, mode := range []Mode{Last, Normal, RGB, HSV, ColorFlow, Moonlight} {
+		parsed, err := ParseMode(mode.String())
+		if err != nil || parsed != mode {
+			t.Errorf("ParseMode(%q) = %d, %v, want %d", mode.String(), parsed, err, mode)
+		}
+	}
+	if got := Moonlight.String(); got != "Moonlight" {
+		t.Errorf("expected Moonlight, got %s", got)
+	}
+	if got := Mode(9).String(); got != "Mode(9)" {
+		t.Errorf("expected Mode(9), got %s", got)
+	}
+	if mode, err := ParseMode("moonlight"); err != nil || mode != Moonlight {
+		t.Errorf("expected case insensitive parse, got %d %v", mode, err)
+	}
+	if _, err := ParseMode("disco"); err == nil {
+		t.Error("expected error for unknown mode")
+	}
+}
+
+func TestSetBrightnessForLightTypeRoutesByLight(t *testing.T) {
+	tests := []struct {
+		lightType LightType
+		want      string
+	}{
+		{Main, "set_bright"},
+		{Ambient, "bg_set_bright"},
+	}
+	for _, tt := range tests {
+		f := newFakeBulb(t)
+		y := f.newBulb()
+
+		if _, err := y.SetBrightnessForLightType(tt.lightType, 30, 500); err != nil {
+			t.Fatalf("unexpected error %s", err)
+		}
+		if got := paramsOf(f.last(tt.want)); got[0] != "30" || got[2] != "500" {
+			t.Errorf("unexpected %s params %v", tt.want, got)
+		}
+	}
+}
+
+func TestStopFlowOnSetStopsFlowBeforeSetRGB(t *testing.T) {
+	tests := []struct {
+		stop bool
+		want []string
+	}{
+		{false, []string{"set_rgb"}},
+		{true, []string{"stop_cf", "set_rgb"}},
+	}
+	for _, tt := range tests {
+		f := newFakeBulb(t)
+		y := f.newBulb(WithStopFlowOnSet(tt.stop))
+
+		if _, err := y.SetRGB(color.RGBA{R: 255, A: 255}); err != nil {
+			t.Fatalf("unexpected error %s", err)
+		}
+		// get_prop of the color mode check is not relevant here
+		var got []string
+		for _, method := range f.methods() {
+			if method != "get_prop" {
+				got = append(got, method)
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("stop flow %t sent %v, want %v", tt.stop, got, tt.want)
+		}
+	}
+}
+
+func TestErrorWhenOffRejectsCommandsForBulbKnownOff(t *testing.T) {
+	f := newFakeBulb(t)
+	f.setProps(map[string]string{"power": "off"})
+	y := f.newBulb(WithAutoPowerOn(false), WithErrorWhenOff(true))
+
+	if on, err := y.IsOn(); err != nil || on {
+		t.Fatalf("expected bulb read as off, got %t %v", on, err)
+	}
+	if _, err := y.SetBrightness(50); err != ErrBulbOff {
+		t.Fatalf("expected ErrBulbOff, got %v", err)
+	}
+	for _, method := range f.methods() {
+		if method == "set_bright" {
+			t.Fatal("set_bright was sent to bulb known to be off")
+		}
+	}
+}
+
+func TestErrorWhenOffSendsCommandsWhenPowerIsUnknown(t *testing.T) {
+	f := newFakeBulb(t)
+	f.setProps(map[string]string{"power": "off"})
+	y := f.newBulb(WithAutoPowerOn(false), WithErrorWhenOff(true))
+
+	if _, err := y.SetBrightness(50); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if methods := f.methods(); !reflect.DeepEqual(methods, []string{"set_bright"}) {
+		t.Errorf("expected only set_bright, got %v", methods)
+	}
+}
+
+func TestTurnOffWithDuration(t *testing.T) {
+	tests := []struct {
+		effect EffectType
+		want   []string
+	}{
+		{Smooth, []string{"off", "smooth", "800"}},
+		{Sudden, []string{"off", "sudden", "0"}},
+	}
+	for _, tt := range tests {
+		f := newFakeBulb(t)
+		y := f.newBulb(WithEffect(tt.effect))
+
+		if _, err := y.TurnOffWithDuration(800); err != nil {
+			t.Fatalf("unexpected error %s", err)
+		}
+		if got := paramsOf(f.last("set_power")); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s effect sent %v, want %v", tt.effect, got, tt.want)
+		}
+	}
+}
+
+func TestSetBrightnessSmoothAlwaysUsesSmoothEffect(t *testing.T) {
+	for _, effect := range []EffectType{Smooth, Sudden} {
+		f := newFakeBulb(t)
+		y := f.newBulb(WithEffect(effect))
+
+		if _, err := y.SetBrightnessSmooth(30, 1500); err != nil {
+			t.Fatalf("unexpected error %s", err)
+		}
+		if got := paramsOf(f.last("set_bright")); !reflect.DeepEqual(got, []string{"30", "smooth", "1500"}) {
+			t.Errorf("configured %s effect sent %v", effect, got)
+		}
+	}
+}
+
+func TestSetBrightnessSmoothRejectsInvalidTarget(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb()
+
+	if _, err := y.SetBrightnessSmooth(0, 1500); err == nil {
+		t.Fatal("expected error")
+	}
+	if methods := f.methods(); len(methods) != 0 {
+		t.Errorf("expected nothing sent, got %v", methods)
+	}
+}
+
+func TestAdjustBrightness(t *testing.T) {
+	tests := []struct {
+		name    string
+		support []string
+		reject  bool
+		want    []string
+	}{
+		{"native", nil, false, []string{"adjust_bright"}},
+		{"rejected by bulb", nil, true, []string{"adjust_bright", "get_prop", "set_bright"}},
+		{"missing in support list", []string{"get_prop", "set_bright"}, false, []string{"get_prop", "set_bright"}},
+	}
+	for _, tt := range tests {
+		f := newFakeBulb(t)
+		f.setProps(map[string]string{"bright": "40"})
+		if tt.reject {
+			f.reply(func(cmd Command) []string {
+				if cmd.Method == "adjust_bright" {
+					return []string{errorLine(cmd.ID, -1, "method not supported")}
+				}
+				return nil
+			})
+		}
+		y := f.newBulb()
+		if tt.support != nil {
+			y = f.discoveredBulb(YeelightParams{ID: "0x1", Support: tt.support})
+		}
+
+		if _, err := y.AdjustBrightness(50, 500); err != nil {
+			t.Fatalf("%s: unexpected error %s", tt.name, err)
+		}
+		if got := f.methods(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: sent %v, want %v", tt.name, got, tt.want)
+		}
+		if len(tt.want) > 1 {
+			if got := paramsOf(f.last("set_bright"))[0]; got != "60" {
+				t.Errorf("%s: expected 40 raised by 50%% to 60, got %s", tt.name, got)
+			}
+		}
+	}
+}
+
+func TestAdjustBrightnessGatedByFirmware(t *testing.T) {
+	tests := []struct {
+		fwVer int
+		want  []string
+	}{
+		{adjustMinFirmware - 1, []string{"get_prop", "set_bright"}},
+		{adjustMinFirmware, []string{"adjust_bright"}},
+		{adjustMinFirmware + 1, []string{"adjust_bright"}},
+		{0, []string{"adjust_bright"}},
+	}
+	for _, tt := range tests {
+		f := newFakeBulb(t)
+		f.setProps(map[string]string{"bright": "40"})
+		y := f.discoveredBulb(YeelightParams{ID: "0x1", FwVer: tt.fwVer})
+
+		if _, err := y.AdjustBrightness(50, 500); err != nil {
+			t.Fatalf("firmware %d: unexpected error %s", tt.fwVer, err)
+		}
+		if got := f.methods(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("firmware %d: sent %v, want %v", tt.fwVer, got, tt.want)
+		}
+	}
+}
+
+func TestFirmwareAtLeast(t *testing.T) {
+	found := newDiscoveredBulb(&YeelightParams{ID: "0x1", Location: "yeelight://127.0.0.1:55443", FwVer: 45})
+	for v, want := range map[int]bool{44: true, 45: true, 46: false} {
+		if got := found.FirmwareAtLeast(v); got != want {
+			t.Errorf("firmware 45 at least %d = %t, want %t", v, got, want)
+		}
+	}
+	if !NewWithOptions("127.0.0.1").FirmwareAtLeast(1000) {
+		t.Error("expected unknown firmware to be assumed recent")
+	}
+}
+
+func TestFirmwareVersion(t *testing.T) {
+	if got := NewWithOptions("127.0.0.1").FirmwareVersion(); got != "" {
+		t.Errorf("expected no firmware for bulb created by ip, got %q", got)
+	}
+	found := newDiscoveredBulb(&YeelightParams{ID: "0x1", Location: "yeelight://127.0.0.1:55443", FwVer: 45})
+	if got := found.FirmwareVersion(); got != "45" {
+		t.Errorf("expected firmware 45, got %q", got)
+	}
+}
+
+func TestSetColorXY(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb()
+
+	if _, err := y.SetColorXY(0.64, 0.33, 70); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	params := paramsOf(f.last("set_scene"))
+	if len(params) != 3 || params[0] != "color" || params[2] != "70" {
+		t.Fatalf("unexpected scene params %v", params)
+	}
+	value, _ := strconv.Atoi(params[1])
+	if r, g, b := value>>16, value>>8&0xff, value&0xff; r < 253 || g > 2 || b > 2 {
+		t.Errorf("expected red, got %06x", value)
+	}
+	if _, err := y.SetColorXY(0.64, 0.33, 0); err == nil {
+		t.Error("expected invalid brightness to be rejected")
+	}
+}
+
+func TestStopFlowOnTurnOffStopsFlowFirst(t *testing.T) {
+	tests := []struct {
+		stop bool
+		want []string
+	}{
+		{false, []string{"set_power"}},
+		{true, []string{"stop_cf", "set_power"}},
+	}
+	for _, tt := range tests {
+		f := newFakeBulb(t)
+		y := f.newBulb(WithStopFlowOnTurnOff(tt.stop))
+
+		if _, err := y.TurnOff(); err != nil {
+			t.Fatalf("unexpected error %s", err)
+		}
+		if got := f.methods(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("stop flow %t sent %v, want %v", tt.stop, got, tt.want)
+		}
+		if got := paramsOf(f.last("set_power"))[0]; got != "off" {
+			t.Errorf("expected set_power off, got %s", got)
+		}
+	}
+}
+
+func TestTurnOnWithSendsExtendedSetPower(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb()
+
+	if _, err := y.TurnOnWith(Moonlight, 30, 500); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if methods := f.methods(); !reflect.DeepEqual(methods, []string{"set_power"}) {
+		t.Fatalf("expected single set_power, got %v", methods)
+	}
+	if got := paramsOf(f.last("set_power")); !reflect.DeepEqual(got, []string{"on", "smooth", "500", "5", "30"}) {
+		t.Errorf("unexpected set_power params %v", got)
+	}
+}
+
+func TestTurnOnWithFallsBackWhenExtendedFormIsRejected(t *testing.T) {
+	f := newFakeBulb(t)
+	f.reply(func(cmd Command) []string {
+		if cmd.Method == "set_power" && len(cmd.Params) > 4 {
+			return []string{errorLine(cmd.ID, -1, "invalid params")}
+		}
+		return nil
+	})
+	y := f.newBulb()
+
+	if _, err := y.TurnOnWith(Moonlight, 30, 500); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	var got [][]string
+	for _, cmd := range f.received() {
+		got = append(got, append([]string{cmd.Method}, paramsOf(cmd)...))
+	}
+	want := [][]string{
+		{"set_power", "on", "smooth", "500", "5", "30"},
+		{"set_power", "on", "smooth", "500", "5"},
+		{"set_bright", "30", "smooth", "500"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestTurnOnWithGatedByFirmware(t *testing.T) {
+	tests := []struct {
+		fwVer int
+		want  []string
+	}{
+		{powerBrightMinFirmware - 1, []string{"set_power", "set_bright"}},
+		{powerBrightMinFirmware, []string{"set_power"}},
+		{powerBrightMinFirmware + 1, []string{"set_power"}},
+	}
+	for _, tt := range tests {
+		f := newFakeBulb(t)
+		y := f.discoveredBulb(YeelightParams{ID: "0x1", FwVer: tt.fwVer})
+
+		if _, err := y.TurnOnWith(Normal, 30, 500); err != nil {
+			t.Fatalf("firmware %d: unexpected error %s", tt.fwVer, err)
+		}
+		if got := f.methods(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("firmware %d: sent %v, want %v", tt.fwVer, got, tt.want)
+		}
+	}
+}
+
+func TestTurnOnWithDoesNotFallBackOnOtherBulbErrors(t *testing.T) {
+	f := newFakeBulb(t)
+	f.reply(func(cmd Command) []string {
+		return []string{errorLine(cmd.ID, -1, "client quota exceeded")}
+	})
+	y := f.newBulb()
+
+	var e *Error
+	if _, err := y.TurnOnWith(Normal, 30, 500); !errors.As(err, &e) || e.Message != "client quota exceeded" {
+		t.Fatalf("expected quota error, got %v", err)
+	}
+	if methods := f.methods(); !reflect.DeepEqual(methods, []string{"set_power"}) {
+		t.Errorf("expected no fallback commands, got %v", methods)
+	}
+}
+
+func TestTurnOnWithDoesNotFallBackOnConnectionError(t *testing.T) {
+	y := NewWithOptions("127.0.0.1", WithPort(refusedPort(t)), WithTimeout(time.Second), WithLogger(quietLogger))
+
+	if _, err := y.TurnOnWith(Normal, 30, 500); !errors.Is(err, ErrLANControlDisabled) {
+		t.Fatalf("expected connection error, got %v", err)
+	}
+}
+
+func TestSetColorHex(t *testing.T) {
+	f := newFakeBulb(t)
+	y := f.newBulb()
+
+	if _, err := y.SetColorHex("#FF8000", 300); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if got := paramsOf(f.last("set_rgb"))[0]; got != strconv.Itoa(0xFF8000) {
+		t.Errorf("expected rgb %d, got %s", 0xFF8000, got)
+	}
+	if _, err := y.SetColorHex("#000000", 300); !errors.Is(err, ErrInvalidColor) {
+		t.Errorf("expected ErrInvalidColor for black, got %v", err)
+	}
+	if _, err := y.SetColorHex("orange", 300); err == nil {
+		t.Error("expected invalid hex to be rejected")
+	}
+}